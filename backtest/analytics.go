@@ -0,0 +1,279 @@
+package backtest
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+)
+
+const daysPerYear = 365.25
+
+// Trade is a closed round trip built from fills using FIFO matching
+type Trade struct {
+	Asset         string    `json:"asset"`
+	Side          string    `json:"side"`
+	Qty           float64   `json:"qty"`
+	EntryPrice    float64   `json:"entry_price"`
+	ExitPrice     float64   `json:"exit_price"`
+	EntryDatetime time.Time `json:"entry_datetime"`
+	ExitDatetime  time.Time `json:"exit_datetime"`
+	PL            float64   `json:"pl"`
+}
+
+// Report is a backtest performance summary. Ratios are fractions, i.e. 0.1 is 10%,
+// Sharpe and Sortino ratios assume zero risk-free rate and are 0 if undefined.
+// AnnualizedReturn is 0 for curves shorter than a day or whose equity drops to 0 or below
+type Report struct {
+	Start            time.Time `json:"start"`
+	End              time.Time `json:"end"`
+	StartEquity      float64   `json:"start_equity"`
+	EndEquity        float64   `json:"end_equity"`
+	TotalReturn      float64   `json:"total_return"`
+	AnnualizedReturn float64   `json:"annualized_return"`
+	Volatility       float64   `json:"volatility"`
+	Sharpe           float64   `json:"sharpe"`
+	Sortino          float64   `json:"sortino"`
+	MaxDrawdown      float64   `json:"max_drawdown"`
+	MaxDrawdownDays  float64   `json:"max_drawdown_days"`
+	WinRate          float64   `json:"win_rate"`
+	ProfitFactor     float64   `json:"profit_factor"`
+	Exposure         float64   `json:"exposure"`
+	Turnover         float64   `json:"turnover"`
+
+	Equity []EquityPoint `json:"equity"`
+	Trades []Trade       `json:"trades"`
+}
+
+// Analyze calculates performance metrics of the equity curve and fills produced by a backtest session
+func Analyze(equity []EquityPoint, fills []Fill) (*Report, error) {
+	if len(equity) < 2 {
+		return nil, errors.New("at least 2 equity points are required")
+	}
+
+	first, last := equity[0], equity[len(equity)-1]
+	if first.Equity <= 0 {
+		return nil, errors.New("start equity must be positive")
+	}
+
+	r := &Report{
+		Start:       first.Datetime,
+		End:         last.Datetime,
+		StartEquity: first.Equity,
+		EndEquity:   last.Equity,
+		TotalReturn: last.Equity/first.Equity - 1,
+		Equity:      equity,
+		Trades:      closeTrades(fills),
+	}
+
+	years := last.Datetime.Sub(first.Datetime).Hours() / 24 / daysPerYear
+	returns := make([]float64, 0, len(equity)-1)
+	for i := 1; i < len(equity); i++ {
+		if equity[i-1].Equity != 0 {
+			returns = append(returns, equity[i].Equity/equity[i-1].Equity-1)
+		}
+	}
+
+	if years > 0 {
+		r.AnnualizedReturn = annualize(r.TotalReturn, years, equity)
+		periodsPerYear := float64(len(returns)) / years
+
+		mean, std, downside := returnStats(returns)
+		r.Volatility = std * math.Sqrt(periodsPerYear)
+		if std > 0 {
+			r.Sharpe = mean / std * math.Sqrt(periodsPerYear)
+		}
+		if downside > 0 {
+			r.Sortino = mean / downside * math.Sqrt(periodsPerYear)
+		}
+	}
+
+	r.MaxDrawdown, r.MaxDrawdownDays = drawdown(equity)
+	r.WinRate, r.ProfitFactor = tradeStats(r.Trades)
+	r.Exposure, r.Turnover = exposure(equity, fills)
+
+	return r, nil
+}
+
+// JSON returns JSON-encoded report
+func (r *Report) JSON() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+// annualize returns compounded yearly return, it is 0 if the curve spans less than a day,
+// equity ever drops to 0 or below, or the result is not finite
+func annualize(totalReturn, years float64, equity []EquityPoint) float64 {
+	if years*daysPerYear < 1 {
+		return 0
+	}
+	for _, p := range equity {
+		if p.Equity <= 0 {
+			return 0
+		}
+	}
+
+	annualized := math.Pow(1+totalReturn, 1/years) - 1
+	if math.IsInf(annualized, 0) || math.IsNaN(annualized) {
+		return 0
+	}
+	return annualized
+}
+
+// returnStats returns mean, standard deviation and downside deviation of returns
+func returnStats(returns []float64) (mean, std, downside float64) {
+	if len(returns) == 0 {
+		return 0, 0, 0
+	}
+
+	for _, v := range returns {
+		mean += v
+	}
+	mean /= float64(len(returns))
+
+	for _, v := range returns {
+		std += (v - mean) * (v - mean)
+		if v < 0 {
+			downside += v * v
+		}
+	}
+
+	return mean, math.Sqrt(std / float64(len(returns))), math.Sqrt(downside / float64(len(returns)))
+}
+
+// drawdown returns maximum drawdown and the longest period in days the equity stayed below its peak
+func drawdown(equity []EquityPoint) (float64, float64) {
+	var maxDrawdown float64
+	var longest time.Duration
+
+	peak := equity[0]
+	for _, p := range equity {
+		if p.Equity >= peak.Equity {
+			peak = p
+			continue
+		}
+
+		maxDrawdown = math.Max(maxDrawdown, 1-p.Equity/peak.Equity)
+		if d := p.Datetime.Sub(peak.Datetime); d > longest {
+			longest = d
+		}
+	}
+	return maxDrawdown, longest.Hours() / 24
+}
+
+// tradeStats returns share of profitable trades and gross profit to gross loss ratio,
+// profit factor is 0 if there are no losing trades
+func tradeStats(trades []Trade) (float64, float64) {
+	if len(trades) == 0 {
+		return 0, 0
+	}
+
+	var wins int
+	var profit, loss float64
+	for _, t := range trades {
+		if t.PL > 0 {
+			wins++
+			profit += t.PL
+		} else {
+			loss -= t.PL
+		}
+	}
+
+	var profitFactor float64
+	if loss > 0 {
+		profitFactor = profit / loss
+	}
+	return float64(wins) / float64(len(trades)), profitFactor
+}
+
+// exposure returns share of equity points with any open position
+// and traded value relative to average equity
+func exposure(equity []EquityPoint, fills []Fill) (float64, float64) {
+	positions := make(map[string]float64)
+	var traded, totalEquity float64
+	var exposed, next int
+
+	for _, p := range equity {
+		for ; next < len(fills) && !fills[next].Datetime.After(p.Datetime); next++ {
+			f := fills[next]
+			positions[f.Asset] += signedQty(f.Side, f.Qty)
+			traded += f.Qty * f.Price
+		}
+
+		for _, qty := range positions {
+			if qty != 0 {
+				exposed++
+				break
+			}
+		}
+		totalEquity += p.Equity
+	}
+
+	avgEquity := totalEquity / float64(len(equity))
+	if avgEquity <= 0 {
+		return float64(exposed) / float64(len(equity)), 0
+	}
+	return float64(exposed) / float64(len(equity)), traded / avgEquity
+}
+
+// closeTrades matches fills into round trips per asset using FIFO
+func closeTrades(fills []Fill) []Trade {
+	type lot struct {
+		qty        float64
+		price      float64
+		commission float64
+		datetime   time.Time
+	}
+
+	lots := make(map[string][]lot)
+	trades := make([]Trade, 0)
+
+	for _, f := range fills {
+		qty := signedQty(f.Side, f.Qty)
+		commission := f.Commission / f.Qty
+		open := lots[f.Asset]
+
+		for len(open) > 0 && qty != 0 && (open[0].qty > 0) != (qty > 0) {
+			closed := math.Min(math.Abs(qty), math.Abs(open[0].qty))
+			side, direction := "long", 1.0
+			if open[0].qty < 0 {
+				side, direction = "short", -1.0
+			}
+
+			trades = append(trades, Trade{
+				Asset:         f.Asset,
+				Side:          side,
+				Qty:           closed,
+				EntryPrice:    open[0].price,
+				ExitPrice:     f.Price,
+				EntryDatetime: open[0].datetime,
+				ExitDatetime:  f.Datetime,
+				PL:            direction*closed*(f.Price-open[0].price) - closed*(open[0].commission+commission),
+			})
+
+			open[0].qty -= direction * closed
+			qty += direction * closed
+			if open[0].qty == 0 {
+				open = open[1:]
+			}
+		}
+
+		if qty != 0 {
+			open = append(open, lot{qty: qty, price: f.Price, commission: commission, datetime: f.Datetime})
+		}
+		lots[f.Asset] = open
+	}
+	return trades
+}
+
+// signedQty returns negative quantity for sell side
+func signedQty(side string, qty float64) float64 {
+	if side == OrderSideSell {
+		return -qty
+	}
+	return qty
+}
+
+// Report returns performance report of the Engine equity curve and fills
+func (e *Engine) Report() (*Report, error) {
+	return Analyze(e.EquityCurve(), e.Fills())
+}
@@ -0,0 +1,121 @@
+package backtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testEquity(values ...float64) []EquityPoint {
+	equity := make([]EquityPoint, len(values))
+	for i, v := range values {
+		equity[i] = EquityPoint{Datetime: time.Date(2021, 1, 1+i, 21, 0, 0, 0, time.UTC), Cash: v, Equity: v}
+	}
+	return equity
+}
+
+func TestAnalyzeReturnsAndDrawdown(t *testing.T) {
+	report, err := Analyze(testEquity(100, 110, 99, 105, 120), nil)
+	assert.NoError(t, err)
+
+	assert.InDelta(t, 0.2, report.TotalReturn, 1e-9)
+	assert.InDelta(t, 0.1, report.MaxDrawdown, 1e-9)
+	assert.Equal(t, 2.0, report.MaxDrawdownDays)
+	assert.True(t, report.AnnualizedReturn > report.TotalReturn)
+	assert.True(t, report.Volatility > 0)
+	assert.True(t, report.Sharpe > 0)
+	assert.True(t, report.Sortino > 0)
+
+	_, err = Analyze(testEquity(100), nil)
+	assert.Error(t, err)
+}
+
+func TestAnalyzeTrades(t *testing.T) {
+	dt := func(d int) time.Time {
+		return time.Date(2021, 1, d, 21, 0, 0, 0, time.UTC)
+	}
+	fills := []Fill{
+		{Asset: "AAPL", Side: OrderSideBuy, Qty: 10, Price: 100, Commission: 1, Datetime: dt(1)},
+		{Asset: "AAPL", Side: OrderSideSell, Qty: 5, Price: 110, Commission: 1, Datetime: dt(2)},
+		{Asset: "AAPL", Side: OrderSideSell, Qty: 10, Price: 90, Datetime: dt(3)},
+		{Asset: "AAPL", Side: OrderSideBuy, Qty: 5, Price: 95, Datetime: dt(4)},
+	}
+
+	report, err := Analyze(testEquity(1000, 1000, 1000, 1000, 1000), fills)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 3, len(report.Trades))
+	assert.Equal(t, "long", report.Trades[0].Side)
+	assert.InDelta(t, 50-0.5-1, report.Trades[0].PL, 1e-9)
+	assert.Equal(t, "long", report.Trades[1].Side)
+	assert.InDelta(t, -50-0.5, report.Trades[1].PL, 1e-9)
+	assert.Equal(t, "short", report.Trades[2].Side)
+	assert.InDelta(t, -25, report.Trades[2].PL, 1e-9)
+
+	assert.InDelta(t, 1.0/3, report.WinRate, 1e-9)
+	assert.InDelta(t, 48.5/75.5, report.ProfitFactor, 1e-9)
+	assert.InDelta(t, 0.6, report.Exposure, 1e-9)
+	assert.InDelta(t, 2.925, report.Turnover, 1e-9)
+}
+
+func TestReportExport(t *testing.T) {
+	engine := NewEngine(testBars(), DefaultCash)
+	engine.Handle(erocRequest("POST", "/orders", "2021-01-04 21:00:00.000000", ErocRequestData{
+		"asset": "AAPL", "side": "buy", "type": "market", "qty": 10,
+	}))
+	engine.Handle(erocRequest("POST", "/orders", "2021-01-05 21:00:00.000000", ErocRequestData{
+		"asset": "AAPL", "side": "sell", "type": "market", "qty": 10,
+	}))
+	engine.Handle(erocRequest("GET", "/accounts", "2021-01-07 21:00:00.000000", nil))
+
+	report, err := engine.Report()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(report.Trades))
+
+	data, err := report.JSON()
+	assert.NoError(t, err)
+
+	var decoded map[string]interface{}
+	assert.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Contains(t, decoded, "sharpe")
+	assert.Contains(t, decoded, "trades")
+
+	var html bytes.Buffer
+	assert.NoError(t, report.WriteHTML(&html))
+	assert.True(t, strings.Contains(html.String(), "<polyline"))
+	assert.True(t, strings.Contains(html.String(), "AAPL"))
+}
+
+func TestAnalyzeIntradayAndLosingCurves(t *testing.T) {
+	intraday := make([]EquityPoint, 60)
+	for i := range intraday {
+		v := 100 + float64(i%7) - float64(i%3)
+		intraday[i] = EquityPoint{Datetime: time.Date(2021, 1, 4, 14, 30+i, 0, 0, time.UTC), Cash: v, Equity: v}
+	}
+
+	curves := map[string][]EquityPoint{
+		"intraday": intraday,
+		"wiped":    testEquity(100, 50, 0, 0),
+		"negative": testEquity(100, 40, -20, 10),
+	}
+	for name, equity := range curves {
+		report, err := Analyze(equity, nil)
+		assert.NoError(t, err, name)
+		assert.Equal(t, 0.0, report.AnnualizedReturn, name)
+
+		fields := reflect.ValueOf(*report)
+		for i := 0; i < fields.NumField(); i++ {
+			if f := fields.Field(i); f.Kind() == reflect.Float64 {
+				assert.False(t, math.IsNaN(f.Float()) || math.IsInf(f.Float(), 0), "%s %s", name, fields.Type().Field(i).Name)
+			}
+		}
+
+		_, err = report.JSON()
+		assert.NoError(t, err, name)
+	}
+}
@@ -3,19 +3,33 @@ package backtest
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 )
 
 const DefaultErrorMessage = "Something bad happen"
 
+// marketDataPaths are endpoints guarded against look-ahead requests
+var marketDataPaths = []string{"bars", "quotes", "trades", "markets"}
+
+// historyPaths are market data endpoints returning a range, which ends with the current bar by default
+var historyPaths = []string{"bars"}
+
 type ErocRequestHeader struct {
-	Start      string `json:"start"`
-	End        string `json:"end"`
-	Datetime   string `json:"datetime"`
-	Resolution string `json:"resolution"`
+	Start      string  `json:"start"`
+	End        string  `json:"end"`
+	Datetime   string  `json:"datetime"`
+	Resolution string  `json:"resolution"`
+	Timezone   string  `json:"timezone,omitempty"`
+	Capital    float64 `json:"capital,omitempty"`
+	Currency   string  `json:"currency,omitempty"`
 }
 
 type ErocRequestData map[string]interface{}
@@ -56,7 +70,14 @@ type Backtest struct {
 	end            string
 	currentBarInfo *BarInfo
 	runtimeEvents  RuntimeEvents
-	zmqConn        *ZmqConn
+	transport      Transport
+	options        *Options
+
+	eventsMu         sync.Mutex
+	eventQueue       []RuntimeEvent
+	eventSeq         uint64
+	subscribers      map[uint64]func(RuntimeEvent)
+	nextSubscriberID uint64
 }
 
 // NewBacktest create new Backtest object with selected start,
@@ -69,15 +90,22 @@ func NewBacktest(start, end, socketUrl string) (*Backtest, error) {
 		return nil, err
 	}
 
+	return NewBacktestWithTransport(start, end, zmqConn), nil
+}
+
+// NewBacktestWithTransport create new Backtest object with selected start,
+// end dates which sends EROC requests using chosen transport, e.g. local Engine
+func NewBacktestWithTransport(start, end string, transport Transport) *Backtest {
 	return &Backtest{
 		start:          start,
 		end:            end,
 		currentBarInfo: &BarInfo{},
-		zmqConn:        zmqConn,
-	}, nil
+		transport:      transport,
+		subscribers:    make(map[uint64]func(RuntimeEvent)),
+	}
 }
 
-// CallErocMethod parse client request data and use it to create new EROC request and send data using ZMQ;
+// CallErocMethod parse client request data and use it to create new EROC request and send data using transport;
 // Returns EROC response as HTTP response.
 func (b *Backtest) CallErocMethod(req *http.Request) *http.Response {
 
@@ -96,9 +124,20 @@ func (b *Backtest) CallErocMethod(req *http.Request) *http.Response {
 		}
 	}
 
+	// Stamp orders with the current bar datetime and prevent look-ahead requests
+	if isOrderRequest(req) {
+		if err := b.stampOrder(erocRequestData); err != nil {
+			return b.rejectHandler(req, "invalid_order_datetime", err.Error())
+		}
+	}
+	forwardURL, err := b.checkLookAhead(req)
+	if err != nil {
+		return b.rejectHandler(req, "look_ahead", err.Error())
+	}
+
 	erocRequest := &ErocRequest{
 		Method: req.Method,
-		Url:    req.URL.String(),
+		Url:    forwardURL.String(),
 		Data:   erocRequestData,
 		Headers: ErocRequestHeader{
 			Start:      b.start,
@@ -108,19 +147,30 @@ func (b *Backtest) CallErocMethod(req *http.Request) *http.Response {
 		},
 	}
 
-	err := b.zmqConn.SendJSON(&erocRequest)
+	// Pass session options set by NewBacktestWithOptions
+	if b.options != nil {
+		erocRequest.Headers.Timezone = b.options.Location.String()
+		erocRequest.Headers.Capital = b.options.Capital
+		erocRequest.Headers.Currency = b.options.Currency
+		if erocRequest.Headers.Resolution == "" {
+			erocRequest.Headers.Resolution = b.options.Resolution
+		}
+	}
+
+	err = b.transport.SendJSON(&erocRequest)
 	if err != nil {
 		return b.errorHandler(req, err, DefaultErrorMessage)
 	}
 
 	var erocResponse ErocResponse
-	err = b.zmqConn.ReceiveJSON(&erocResponse)
+	err = b.transport.ReceiveJSON(&erocResponse)
 	if err != nil {
 		return b.errorHandler(req, err, DefaultErrorMessage)
 	}
 
-	// Set runtime events
+	// Set runtime events and queue them, so they are not lost by the next request
 	b.runtimeEvents = erocResponse.Events
+	b.queueEvents(erocResponse.Events)
 
 	erocJSONResponse, err := json.Marshal(BacktestResponse{
 		Errors: erocResponse.Errors,
@@ -148,6 +198,121 @@ func (b *Backtest) CallErocMethod(req *http.Request) *http.Response {
 
 }
 
+// stampOrder adds current bar datetime to the order data unless it's already set
+// and validates it's inside of the backtest range, date-only end is compared as the end of that day
+func (b *Backtest) stampOrder(data ErocRequestData) error {
+	if _, ok := data["datetime"]; !ok && b.currentBarInfo.Datetime != "" {
+		data["datetime"] = b.currentBarInfo.Datetime
+	}
+
+	value, ok := data["datetime"].(string)
+	if !ok {
+		if data["datetime"] == nil {
+			return nil
+		}
+		return errors.New("order datetime must be a string")
+	}
+
+	dt, err := ParseDatetimeInLocation(value, b.Location())
+	if err != nil {
+		return err
+	}
+
+	// Bounds which are empty or don't parse, e.g. of legacy sessions, are not checked
+	if start, err := ParseDatetimeInLocation(b.start, b.Location()); err == nil && dt.Before(start) {
+		return fmt.Errorf("order datetime %s is outside of backtest range %s - %s", value, b.start, b.end)
+	}
+	if end, err := parseEnd(b.end, b.Location()); err == nil && dt.After(end) {
+		return fmt.Errorf("order datetime %s is outside of backtest range %s - %s", value, b.start, b.end)
+	}
+	return nil
+}
+
+// checkLookAhead returns URL the request is forwarded with, or an error if market data request reaches past
+// the current bar datetime. Missing end of history requests is set to the current bar datetime on a copy
+// of the URL, and date-only end is compared as the end of that day
+func (b *Backtest) checkLookAhead(req *http.Request) (*url.URL, error) {
+	if b.currentBarInfo.Datetime == "" || req.Method != http.MethodGet || !hasPathSegment(req, marketDataPaths) {
+		return req.URL, nil
+	}
+
+	current, err := ParseDatetimeInLocation(b.currentBarInfo.Datetime, b.Location())
+	if err != nil {
+		return nil, err
+	}
+
+	forwardURL := req.URL
+	query := req.URL.Query()
+	if query.Get("end") == "" && hasPathSegment(req, historyPaths) {
+		query.Set("end", b.currentBarInfo.Datetime)
+
+		copied := *req.URL
+		copied.RawQuery = query.Encode()
+		forwardURL = &copied
+	}
+
+	for _, key := range []string{"start", "end", "datetime"} {
+		value := query.Get(key)
+		if value == "" {
+			continue
+		}
+
+		parse := ParseDatetimeInLocation
+		if key == "end" {
+			parse = parseEnd
+		}
+		dt, err := parse(value, b.Location())
+		if err != nil {
+			return nil, err
+		}
+		if dt.After(current) {
+			return nil, fmt.Errorf("%s %s is after the current bar datetime %s", key, value, b.currentBarInfo.Datetime)
+		}
+	}
+	return forwardURL, nil
+}
+
+// parseEnd parses range end in selected location, date-only value is the end of that day
+func parseEnd(value string, loc *time.Location) (time.Time, error) {
+	dt, err := ParseDatetimeInLocation(value, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if isDateOnly(value) {
+		dt = dt.AddDate(0, 0, 1).Add(-time.Microsecond)
+	}
+	return dt, nil
+}
+
+// isDateOnly returns true if the value is a date without time
+func isDateOnly(value string) bool {
+	_, err := time.Parse("2006-01-02", value)
+	return err == nil
+}
+
+// isOrderRequest returns true if request creates or updates an order
+func isOrderRequest(req *http.Request) bool {
+	return (req.Method == http.MethodPost || req.Method == http.MethodPatch || req.Method == http.MethodPut) &&
+		hasPathSegment(req, []string{"orders"})
+}
+
+// hasPathSegment returns true if the first segment of request path is one of selected ones,
+// e.g. "bars" of "/bars/AAPL", but not of "/barsfoo"
+func hasPathSegment(req *http.Request, segments []string) bool {
+	first := strings.SplitN(strings.TrimPrefix(req.URL.Path, "/"), "/", 2)[0]
+	for _, segment := range segments {
+		if first == segment {
+			return true
+		}
+	}
+	return false
+}
+
+// RoundTrip implements http.RoundTripper, so Backtest can be used as HTTP client transport
+func (b *Backtest) RoundTrip(req *http.Request) (*http.Response, error) {
+	return b.CallErocMethod(req), nil
+}
+
 // errorHandler returns HTTP Bad Gateway error if something unexpected happened inside CallErocMethod function
 func (b *Backtest) errorHandler(req *http.Request, err error, message string) *http.Response {
 
@@ -182,17 +347,65 @@ func (b *Backtest) errorHandler(req *http.Request, err error, message string) *h
 	return res
 }
 
+// rejectHandler returns HTTP Bad Request error if request was rejected before sending it to the EROC router
+func (b *Backtest) rejectHandler(req *http.Request, id, message string) *http.Response {
+	erocJSONResponse, err := json.Marshal(BacktestResponse{
+		Errors: []ErocError{{ID: id, Message: message}},
+		Data:   make(map[string]interface{}),
+	})
+	if err != nil {
+		return b.errorHandler(req, err, DefaultErrorMessage)
+	}
+
+	res := &http.Response{
+		Body: ioutil.NopCloser(bytes.NewBuffer(erocJSONResponse)),
+
+		StatusCode: http.StatusBadRequest,
+		Status:     fmt.Sprintf("%d %s", http.StatusBadRequest, http.StatusText(http.StatusBadRequest)),
+
+		Proto:      req.Proto,
+		ProtoMajor: req.ProtoMajor,
+		ProtoMinor: req.ProtoMinor,
+
+		Request: req,
+	}
+	req.Response = res
+
+	return res
+}
+
 // SetCurrentBarInfo set currentBarInfo datetime and resolution
 func (b *Backtest) SetCurrentBarInfo(info *BarInfo) {
 	b.currentBarInfo = info
 }
 
-// GetRuntimeEvents returns current Backtest events data
+// CurrentDatetime returns datetime of the current bar, false if it isn't set yet
+func (b *Backtest) CurrentDatetime() (time.Time, bool) {
+	if b.currentBarInfo == nil || b.currentBarInfo.Datetime == "" {
+		return time.Time{}, false
+	}
+
+	dt, err := ParseDatetimeInLocation(b.currentBarInfo.Datetime, b.Location())
+	if err != nil {
+		return time.Time{}, false
+	}
+	return dt, true
+}
+
+// GetRuntimeEvents returns raw events data of the last EROC response, use Drain to get all events
 func (b *Backtest) GetRuntimeEvents() map[string]interface{} {
 	return b.runtimeEvents
 }
 
-// Close ZMQ connection
+// Location returns timezone of the session datetimes
+func (b *Backtest) Location() *time.Location {
+	if b.options != nil {
+		return b.options.Location
+	}
+	return time.UTC
+}
+
+// Close EROC transport connection
 func (b *Backtest) Close() {
-	b.zmqConn.Close()
+	b.transport.Close()
 }
@@ -1,3 +1,141 @@
 package backtest
 
-// TODO
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"testing"
+)
+
+// recordingTransport keeps the last sent request and answers with an empty response
+type recordingTransport struct {
+	request ErocRequest
+}
+
+func (r *recordingTransport) SendJSON(src interface{}) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &r.request)
+}
+
+func (r *recordingTransport) ReceiveJSON(dst interface{}) error {
+	return json.Unmarshal([]byte(`{"status":201,"errors":[],"data":{}}`), dst)
+}
+
+func (r *recordingTransport) Close() {}
+
+func postOrder(t *testing.T, bt *Backtest, order map[string]interface{}) *http.Response {
+	data, err := json.Marshal(order)
+	assert.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodPost, "/orders", bytes.NewBuffer(data))
+	assert.NoError(t, err)
+
+	return bt.CallErocMethod(req)
+}
+
+func TestOrderIsStampedWithBarDatetime(t *testing.T) {
+	transport := &recordingTransport{}
+	bt := NewBacktestWithTransport("2021-01-04 21:00:00.000000", "2021-01-07 21:00:00.000000", transport)
+	bt.SetCurrentBarInfo(&BarInfo{Datetime: "2021-01-05 21:00:00.000000", Resolution: "1day"})
+
+	res := postOrder(t, bt, map[string]interface{}{"asset": "AAPL", "side": "buy", "type": "market", "qty": 1})
+	assert.Equal(t, 201, res.StatusCode)
+	assert.Equal(t, "2021-01-05 21:00:00.000000", transport.request.Data["datetime"])
+
+	res = postOrder(t, bt, map[string]interface{}{"asset": "AAPL", "qty": 1, "datetime": "2021-01-06 21:00:00.000000"})
+	assert.Equal(t, 201, res.StatusCode)
+	assert.Equal(t, "2021-01-06 21:00:00.000000", transport.request.Data["datetime"])
+}
+
+func TestOrderOutsideOfBacktestRangeIsRejected(t *testing.T) {
+	transport := &recordingTransport{}
+	bt := NewBacktestWithTransport("2021-01-04 21:00:00.000000", "2021-01-07 21:00:00.000000", transport)
+	bt.SetCurrentBarInfo(&BarInfo{Datetime: "2021-01-08 21:00:00.000000", Resolution: "1day"})
+
+	res := postOrder(t, bt, map[string]interface{}{"asset": "AAPL", "side": "buy", "type": "market", "qty": 1})
+	assert.Equal(t, 400, res.StatusCode)
+
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), `"id":"invalid_order_datetime"`)
+	assert.Equal(t, "", transport.request.Method)
+}
+
+func TestOrderOnLastDayOfDateOnlyRangeIsAccepted(t *testing.T) {
+	transport := &recordingTransport{}
+	bt := NewBacktestWithTransport("2021-01-04", "2021-01-07", transport)
+	bt.SetCurrentBarInfo(&BarInfo{Datetime: "2021-01-07 21:00:00.000000", Resolution: "1day"})
+
+	res := postOrder(t, bt, map[string]interface{}{"asset": "AAPL", "side": "buy", "type": "market", "qty": 1})
+	assert.Equal(t, 201, res.StatusCode)
+	assert.Equal(t, "2021-01-07 21:00:00.000000", transport.request.Data["datetime"])
+
+	bt.SetCurrentBarInfo(&BarInfo{Datetime: "2021-01-08 00:00:00.000000", Resolution: "1day"})
+	res = postOrder(t, bt, map[string]interface{}{"asset": "AAPL", "side": "buy", "type": "market", "qty": 1})
+	assert.Equal(t, 400, res.StatusCode)
+}
+
+func TestOrderWithoutBacktestRangeIsNotChecked(t *testing.T) {
+	for _, bounds := range [][2]string{{"", ""}, {"foo", "bar"}, {"", "2021-01-07"}} {
+		transport := &recordingTransport{}
+		bt := NewBacktestWithTransport(bounds[0], bounds[1], transport)
+		bt.SetCurrentBarInfo(&BarInfo{Datetime: "2021-01-05 21:00:00.000000", Resolution: "1day"})
+
+		res := postOrder(t, bt, map[string]interface{}{"asset": "AAPL", "side": "buy", "type": "market", "qty": 1})
+		assert.Equal(t, 201, res.StatusCode, bounds)
+		assert.Equal(t, "2021-01-05 21:00:00.000000", transport.request.Data["datetime"], bounds)
+	}
+}
+
+func TestLookAheadMarketDataRequestIsRejected(t *testing.T) {
+	transport := &recordingTransport{}
+	bt := NewBacktestWithTransport("2021-01-04 21:00:00.000000", "2021-01-07 21:00:00.000000", transport)
+	bt.SetCurrentBarInfo(&BarInfo{Datetime: "2021-01-05 21:00:00.000000", Resolution: "1day"})
+
+	req, err := http.NewRequest(http.MethodGet, "/bars?assets=AAPL&end=2021-01-06", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, bt.CallErocMethod(req).StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, "/bars?assets=AAPL&start=2021-01-04&end=2021-01-05 21:00:00.000000", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, bt.CallErocMethod(req).StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, "/orders?end=2021-01-06", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, bt.CallErocMethod(req).StatusCode)
+	// Date-only end covers the whole day
+	req, err = http.NewRequest(http.MethodGet, "/bars?assets=AAPL&end=2021-01-05", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, bt.CallErocMethod(req).StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, "/bars?assets=AAPL&end=2021-01-04", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, bt.CallErocMethod(req).StatusCode)
+
+	// Missing end is limited by the current bar
+	req, err = http.NewRequest(http.MethodGet, "/bars?assets=AAPL&start=2021-01-04", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, bt.CallErocMethod(req).StatusCode)
+	assert.Equal(t, "/bars?assets=AAPL&end=2021-01-05+21%3A00%3A00.000000&start=2021-01-04", transport.request.Url)
+	assert.Equal(t, "assets=AAPL&start=2021-01-04", req.URL.RawQuery)
+
+	// Snapshots aren't limited by the end, but their range is still checked
+	req, err = http.NewRequest(http.MethodGet, "/quotes?assets=AAPL", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, bt.CallErocMethod(req).StatusCode)
+	assert.Equal(t, "/quotes?assets=AAPL", transport.request.Url)
+
+	req, err = http.NewRequest(http.MethodGet, "/markets/XNYS?datetime=2021-01-06", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 400, bt.CallErocMethod(req).StatusCode)
+
+	// Paths are matched by whole segments
+	req, err = http.NewRequest(http.MethodGet, "/barsfoo?end=2021-01-06", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 201, bt.CallErocMethod(req).StatusCode)
+}
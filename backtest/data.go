@@ -0,0 +1,66 @@
+package backtest
+
+import (
+	"math"
+	"time"
+)
+
+// DataSource provides historical bars for the local Engine
+type DataSource interface {
+	// Bars returns bars of every asset between start and end inclusive
+	Bars(start, end time.Time) (map[string][]Bar, error)
+}
+
+// BarsSource is a DataSource of bars kept in memory
+type BarsSource map[string][]Bar
+
+// Bars returns bars of every asset between start and end inclusive
+func (s BarsSource) Bars(start, end time.Time) (map[string][]Bar, error) {
+	bars := make(map[string][]Bar, len(s))
+	for asset, assetBars := range s {
+		for _, bar := range assetBars {
+			if !bar.Datetime.Before(start) && !bar.Datetime.After(end) {
+				bars[asset] = append(bars[asset], bar)
+			}
+		}
+	}
+	return bars, nil
+}
+
+// Resample aggregates bars sorted by datetime into bars of a coarser step. Buckets are right-closed,
+// (t-step, t], their ends are aligned to the anchor and each aggregated bar is dated by its bucket end,
+// so every bar falls into a single bucket. Buckets ending after until aren't complete yet and are dropped,
+// zero until keeps every bucket
+func Resample(bars []Bar, step time.Duration, anchor, until time.Time) []Bar {
+	var resampled []Bar
+	for _, bar := range bars {
+		end := bucketEnd(bar.Datetime, step, anchor)
+		if !until.IsZero() && end.After(until) {
+			break
+		}
+
+		if len(resampled) == 0 || !resampled[len(resampled)-1].Datetime.Equal(end) {
+			bar.Datetime = end
+			resampled = append(resampled, bar)
+			continue
+		}
+
+		last := &resampled[len(resampled)-1]
+		last.High = math.Max(last.High, bar.High)
+		last.Low = math.Min(last.Low, bar.Low)
+		last.Close = bar.Close
+		last.Volume += bar.Volume
+	}
+	return resampled
+}
+
+// bucketEnd returns the end of the right-closed bucket of the datetime, bucket ends are the anchor
+// shifted by whole steps
+func bucketEnd(dt time.Time, step time.Duration, anchor time.Time) time.Time {
+	offset := anchor.Sub(anchor.Truncate(step))
+	end := dt.Add(-offset).Truncate(step).Add(offset)
+	if end.Before(dt) {
+		end = end.Add(step)
+	}
+	return end.In(dt.Location())
+}
@@ -0,0 +1,820 @@
+package backtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DatetimeLayout = "2006-01-02 15:04:05.000000"
+	BarsKeyLayout  = "2006-01-02T15:04:05.999999999"
+
+	DefaultCash     = 100000
+	DefaultCurrency = "USD"
+	AccountID       = "backtest"
+)
+
+const (
+	OrderSideBuy  = "buy"
+	OrderSideSell = "sell"
+
+	OrderTypeMarket = "market"
+	OrderTypeLimit  = "limit"
+	OrderTypeStop   = "stop"
+
+	OrderStatusAccepted        = "accepted"
+	OrderStatusPartiallyFilled = "partially_filled"
+	OrderStatusFilled          = "filled"
+	OrderStatusCanceled        = "canceled"
+	OrderStatusRejected        = "rejected"
+)
+
+// Bar is a single OHLCV bar of an asset
+type Bar struct {
+	Datetime time.Time
+	Open     float64
+	High     float64
+	Low      float64
+	Close    float64
+	Volume   float64
+}
+
+// barJSON is a bar representation used by market data endpoints
+type barJSON struct {
+	Open   float64 `json:"o"`
+	High   float64 `json:"h"`
+	Low    float64 `json:"l"`
+	Close  float64 `json:"c"`
+	Volume float64 `json:"v"`
+}
+
+// Order is an order state maintained by Engine
+type Order struct {
+	OrderID      string   `json:"order_id"`
+	Asset        string   `json:"asset"`
+	Side         string   `json:"side"`
+	Type         string   `json:"type"`
+	Tif          string   `json:"tif"`
+	Qty          float64  `json:"qty"`
+	FilledQty    float64  `json:"filled_qty"`
+	LimitPrice   *float64 `json:"limit_price"`
+	StopPrice    *float64 `json:"stop_price"`
+	AvgFillPrice *float64 `json:"avg_fill_price"`
+	Commission   float64  `json:"commission"`
+	Status       string   `json:"status"`
+	SubmittedAt  string   `json:"submitted_at"`
+	FilledAt     *string  `json:"filled_at"`
+	CanceledAt   *string  `json:"canceled_at"`
+	Comment      *string  `json:"comment"`
+	StrategyID   string   `json:"strategy_id"`
+	AccountID    string   `json:"account_id"`
+}
+
+// isOpen returns true if the order can still be executed
+func (o *Order) isOpen() bool {
+	return o.Status == OrderStatusAccepted || o.Status == OrderStatusPartiallyFilled
+}
+
+// orderRequest is a payload of the order creation request
+type orderRequest struct {
+	Asset      string   `json:"asset"`
+	Side       string   `json:"side"`
+	Type       string   `json:"type"`
+	Tif        string   `json:"tif"`
+	Qty        float64  `json:"qty"`
+	LimitPrice *float64 `json:"limit_price"`
+	StopPrice  *float64 `json:"stop_price"`
+	Comment    *string  `json:"comment"`
+	Strategy   string   `json:"strategy"`
+}
+
+// Fill is a single execution of an order
+type Fill struct {
+	OrderID    string    `json:"order_id"`
+	Asset      string    `json:"asset"`
+	Side       string    `json:"side"`
+	Qty        float64   `json:"qty"`
+	Price      float64   `json:"price"`
+	Commission float64   `json:"commission"`
+	Datetime   time.Time `json:"datetime"`
+}
+
+// Position is an asset holding maintained by Engine
+type Position struct {
+	Asset       string  `json:"asset"`
+	Qty         float64 `json:"qty"`
+	AvgPrice    float64 `json:"avg_entry_price"`
+	MarketPrice float64 `json:"current_price"`
+	RealizedPL  float64 `json:"realized_pl"`
+}
+
+// MarketValue returns position value using last known asset price
+func (p *Position) MarketValue() float64 {
+	return p.Qty * p.MarketPrice
+}
+
+// UnrealizedPL returns position profit or loss using last known asset price
+func (p *Position) UnrealizedPL() float64 {
+	return p.Qty * (p.MarketPrice - p.AvgPrice)
+}
+
+// EquityPoint is an account snapshot taken after every processed bar
+type EquityPoint struct {
+	Datetime time.Time `json:"datetime"`
+	Cash     float64   `json:"cash"`
+	Equity   float64   `json:"equity"`
+}
+
+// Engine is a local EROC router which replays historical bars, matches orders against them
+// and tracks positions and cash. It implements Transport, so it can be used instead of ZmqConn.
+type Engine struct {
+	mu sync.Mutex
+
+	bars    map[string][]Bar
+	cursor  map[string]int
+	now     time.Time
+	started bool
+
+	cash      float64
+	currency  string
+	orders    []*Order
+	positions map[string]*Position
+	prices    map[string]float64
+	fills     []Fill
+	equity    []EquityPoint
+	events    RuntimeEvents
+	nextID    int
+
+	commission CommissionModel
+	slippage   SlippageModel
+	fillModel  FillModel
+	fillTiming FillTiming
+	volumeUsed map[string]float64
+
+	response []byte
+}
+
+// NewEngine create new Engine with selected historical bars per asset, initial cash
+// and optional commission, slippage and fill models
+func NewEngine(bars map[string][]Bar, cash float64, opts ...EngineOption) *Engine {
+	sorted := make(map[string][]Bar, len(bars))
+	for asset, assetBars := range bars {
+		b := make([]Bar, len(assetBars))
+		copy(b, assetBars)
+		sort.SliceStable(b, func(i, j int) bool {
+			return b[i].Datetime.Before(b[j].Datetime)
+		})
+		sorted[asset] = b
+	}
+
+	e := &Engine{
+		bars:       sorted,
+		cursor:     make(map[string]int),
+		cash:       cash,
+		currency:   DefaultCurrency,
+		positions:  make(map[string]*Position),
+		prices:     make(map[string]float64),
+		events:     RuntimeEvents{},
+		commission: NoCommission{},
+		slippage:   NoSlippage{},
+		fillModel:  FullFill{},
+		fillTiming: FillNextBarOpen,
+		volumeUsed: make(map[string]float64),
+	}
+
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	return e
+}
+
+// SendJSON handles JSON-encoded EROC request and prepares response for ReceiveJSON
+func (e *Engine) SendJSON(src interface{}) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+
+	var req ErocRequest
+	if err = json.Unmarshal(data, &req); err != nil {
+		return err
+	}
+
+	e.response, err = json.Marshal(e.Handle(&req))
+	return err
+}
+
+// ReceiveJSON parse response of the last sent request into selected struct
+func (e *Engine) ReceiveJSON(dst interface{}) error {
+	if e.response == nil {
+		return errors.New("no request was sent")
+	}
+
+	data := e.response
+	e.response = nil
+
+	return json.Unmarshal(data, dst)
+}
+
+// Close does nothing, Engine has no connection to release
+func (e *Engine) Close() {}
+
+// Handle advances Engine clock to the request datetime, matches pending orders
+// against bars passed in between and serves the request
+func (e *Engine) Handle(req *ErocRequest) *ErocResponse {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	location := time.UTC
+	if req.Headers.Timezone != "" {
+		loc, err := time.LoadLocation(req.Headers.Timezone)
+		if err != nil {
+			return e.errorResponse(http.StatusBadRequest, "invalid_timezone", err.Error())
+		}
+		location = loc
+	}
+
+	// Bars before the backtest start are only used as last known prices
+	if !e.started && req.Headers.Start != "" {
+		start, err := ParseDatetimeInLocation(req.Headers.Start, location)
+		if err != nil {
+			return e.errorResponse(http.StatusBadRequest, "invalid_datetime", err.Error())
+		}
+		e.skipBefore(start)
+	}
+	e.started = true
+
+	if req.Headers.Datetime != "" {
+		dt, err := ParseDatetimeInLocation(req.Headers.Datetime, location)
+		if err != nil {
+			return e.errorResponse(http.StatusBadRequest, "invalid_datetime", err.Error())
+		}
+		e.advance(dt)
+	}
+
+	u, err := url.Parse(req.Url)
+	if err != nil {
+		return e.errorResponse(http.StatusBadRequest, "invalid_url", err.Error())
+	}
+
+	path := strings.Split(strings.Trim(u.Path, "/"), "/")
+
+	var res *ErocResponse
+	switch {
+	case path[0] == "accounts" && len(path) == 1 && req.Method == http.MethodGet:
+		res = e.dataResponse(http.StatusOK, []interface{}{e.account()})
+	case path[0] == "positions" && len(path) == 1 && req.Method == http.MethodGet:
+		res = e.dataResponse(http.StatusOK, e.positionList())
+	case path[0] == "orders" && len(path) == 1 && req.Method == http.MethodGet:
+		res = e.dataResponse(http.StatusOK, e.orderList())
+	case path[0] == "orders" && len(path) == 1 && req.Method == http.MethodPost:
+		res = e.createOrder(req.Data)
+	case path[0] == "orders" && len(path) == 2 && req.Method == http.MethodGet:
+		res = e.getOrder(path[1])
+	case path[0] == "orders" && len(path) == 2 && req.Method == http.MethodDelete:
+		res = e.cancelOrder(path[1])
+	case path[0] == "bars" && len(path) <= 2 && req.Method == http.MethodGet:
+		res = e.getBars(u.Query(), location)
+	default:
+		return e.errorResponse(http.StatusBadGateway, "internal_server_error", "Endpoint not found")
+	}
+
+	// Hand over events collected since the previous request
+	res.Events = e.events
+	e.events = RuntimeEvents{}
+
+	return res
+}
+
+// Fills returns all order executions
+func (e *Engine) Fills() []Fill {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	fills := make([]Fill, len(e.fills))
+	copy(fills, e.fills)
+	return fills
+}
+
+// EquityCurve returns account snapshots taken after every processed bar
+func (e *Engine) EquityCurve() []EquityPoint {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	equity := make([]EquityPoint, len(e.equity))
+	copy(equity, e.equity)
+	return equity
+}
+
+// Positions returns current open positions
+func (e *Engine) Positions() []Position {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.positionList()
+}
+
+// Cash returns current account cash
+func (e *Engine) Cash() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.cash
+}
+
+// advance processes all bars after the current Engine clock up to and including selected datetime
+func (e *Engine) advance(to time.Time) {
+	for {
+		next, ok := e.nextBarTime(to)
+		if !ok {
+			break
+		}
+
+		for asset, bars := range e.bars {
+			i := e.cursor[asset]
+			if i < len(bars) && bars[i].Datetime.Equal(next) {
+				e.volumeUsed[asset] = 0
+				e.matchOrders(asset, &bars[i])
+				e.prices[asset] = bars[i].Close
+				e.cursor[asset] = i + 1
+			}
+		}
+
+		e.equity = append(e.equity, EquityPoint{Datetime: next, Cash: e.cash, Equity: e.totalEquity()})
+	}
+
+	if to.After(e.now) {
+		e.now = to
+	}
+}
+
+// skipBefore moves Engine clock to the bars of selected datetime without matching orders and taking snapshots
+func (e *Engine) skipBefore(dt time.Time) {
+	for asset, bars := range e.bars {
+		i := e.cursor[asset]
+		for ; i < len(bars) && bars[i].Datetime.Before(dt); i++ {
+			e.prices[asset] = bars[i].Close
+		}
+		e.cursor[asset] = i
+	}
+}
+
+// nextBarTime returns the earliest unprocessed bar datetime not later than selected datetime
+func (e *Engine) nextBarTime(to time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+
+	for asset, bars := range e.bars {
+		i := e.cursor[asset]
+		if i >= len(bars) || bars[i].Datetime.After(to) {
+			continue
+		}
+		if !found || bars[i].Datetime.Before(next) {
+			next = bars[i].Datetime
+			found = true
+		}
+	}
+	return next, found
+}
+
+// matchOrders fills open orders of the asset which price conditions are met by the bar
+func (e *Engine) matchOrders(asset string, bar *Bar) {
+	for _, o := range e.orders {
+		if o.Asset == asset && o.isOpen() {
+			e.matchOrder(o, bar)
+		}
+	}
+}
+
+// matchOrder fills the order if the bar triggers it, limiting quantity by the fill model
+// and adjusting price by the slippage model
+func (e *Engine) matchOrder(o *Order, bar *Bar) {
+	price, ok := matchPrice(o, bar, e.fillTiming)
+	if !ok {
+		return
+	}
+
+	qty := math.Min(o.Qty-o.FilledQty, e.fillModel.MaxQty(bar)-e.volumeUsed[o.Asset])
+	if qty <= 0 {
+		return
+	}
+	e.volumeUsed[o.Asset] += qty
+
+	// Limit orders are executed at the limit price or better
+	if o.Type != OrderTypeLimit {
+		price = e.slippage.Price(o.Side, qty, price, bar)
+	}
+
+	e.fill(o, qty, price, bar.Datetime)
+}
+
+// currentBar returns the bar of the asset at the current Engine clock or nil if there is none
+func (e *Engine) currentBar(asset string) *Bar {
+	i := e.cursor[asset] - 1
+	if i < 0 || !e.bars[asset][i].Datetime.Equal(e.now) {
+		return nil
+	}
+	return &e.bars[asset][i]
+}
+
+// matchPrice returns execution price of the order if the bar triggers it
+func matchPrice(o *Order, bar *Bar, timing FillTiming) (float64, bool) {
+	buy := o.Side == OrderSideBuy
+
+	if timing == FillSameBarClose {
+		switch {
+		case o.Type == OrderTypeMarket:
+			return bar.Close, true
+		case o.Type == OrderTypeLimit && buy && bar.Close <= *o.LimitPrice:
+			return bar.Close, true
+		case o.Type == OrderTypeLimit && !buy && bar.Close >= *o.LimitPrice:
+			return bar.Close, true
+		case o.Type == OrderTypeStop && buy && bar.Close >= *o.StopPrice:
+			return bar.Close, true
+		case o.Type == OrderTypeStop && !buy && bar.Close <= *o.StopPrice:
+			return bar.Close, true
+		}
+		return 0, false
+	}
+
+	switch o.Type {
+	case OrderTypeMarket:
+		return bar.Open, true
+	case OrderTypeLimit:
+		limit := *o.LimitPrice
+		if buy && bar.Low <= limit {
+			return math.Min(bar.Open, limit), true
+		}
+		if !buy && bar.High >= limit {
+			return math.Max(bar.Open, limit), true
+		}
+	case OrderTypeStop:
+		stop := *o.StopPrice
+		if buy && bar.High >= stop {
+			return math.Max(bar.Open, stop), true
+		}
+		if !buy && bar.Low <= stop {
+			return math.Min(bar.Open, stop), true
+		}
+	}
+	return 0, false
+}
+
+// fill executes selected quantity of the order and updates cash and position
+func (e *Engine) fill(o *Order, qty, price float64, dt time.Time) {
+	signed := signedQty(o.Side, qty)
+
+	pos, ok := e.positions[o.Asset]
+	if !ok {
+		pos = &Position{Asset: o.Asset}
+		e.positions[o.Asset] = pos
+	}
+
+	switch {
+	case pos.Qty == 0 || (pos.Qty > 0) == (signed > 0):
+		// Opening or increasing position
+		pos.AvgPrice = (pos.AvgPrice*math.Abs(pos.Qty) + price*qty) / (math.Abs(pos.Qty) + qty)
+	case math.Abs(signed) <= math.Abs(pos.Qty):
+		// Reducing position
+		pos.RealizedPL += -signed * (price - pos.AvgPrice)
+	default:
+		// Reversing position
+		pos.RealizedPL += pos.Qty * (price - pos.AvgPrice)
+		pos.AvgPrice = price
+	}
+	pos.Qty += signed
+	pos.MarketPrice = price
+	if pos.Qty == 0 {
+		pos.AvgPrice = 0
+	}
+
+	commission := e.commission.Commission(qty, price)
+	e.cash -= signed*price + commission
+
+	avg := price
+	if o.AvgFillPrice != nil {
+		avg = (*o.AvgFillPrice*o.FilledQty + price*qty) / (o.FilledQty + qty)
+	}
+	o.AvgFillPrice = &avg
+	o.FilledQty += qty
+	o.Commission += commission
+
+	if o.FilledQty >= o.Qty {
+		filledAt := dt.Format(DatetimeLayout)
+		o.FilledAt = &filledAt
+		o.Status = OrderStatusFilled
+		e.addEvent("order_filled", *o)
+	} else {
+		o.Status = OrderStatusPartiallyFilled
+		e.addEvent("order_partially_filled", *o)
+	}
+
+	e.fills = append(e.fills, Fill{
+		OrderID:    o.OrderID,
+		Asset:      o.Asset,
+		Side:       o.Side,
+		Qty:        qty,
+		Price:      price,
+		Commission: commission,
+		Datetime:   dt,
+	})
+}
+
+// createOrder validates order request and accepts it for matching
+func (e *Engine) createOrder(data ErocRequestData) *ErocResponse {
+	var req orderRequest
+
+	raw, err := json.Marshal(data)
+	if err == nil {
+		err = json.Unmarshal(raw, &req)
+	}
+	if err != nil {
+		return e.errorResponse(http.StatusBadRequest, "invalid_order", err.Error())
+	}
+
+	if err = e.validateOrder(&req); err != nil {
+		return e.errorResponse(http.StatusBadRequest, "invalid_order", err.Error())
+	}
+
+	if req.Tif == "" {
+		req.Tif = "gtc"
+	}
+
+	e.nextID++
+	o := &Order{
+		OrderID:     fmt.Sprintf("%s-%d", AccountID, e.nextID),
+		Asset:       req.Asset,
+		Side:        req.Side,
+		Type:        req.Type,
+		Tif:         req.Tif,
+		Qty:         req.Qty,
+		LimitPrice:  req.LimitPrice,
+		StopPrice:   req.StopPrice,
+		Status:      OrderStatusAccepted,
+		SubmittedAt: e.now.Format(DatetimeLayout),
+		Comment:     req.Comment,
+		StrategyID:  req.Strategy,
+		AccountID:   AccountID,
+	}
+
+	price, known := e.estimatePrice(o)
+	if req.Side == OrderSideBuy && !known {
+		o.Status = OrderStatusRejected
+		e.orders = append(e.orders, o)
+		e.addEvent("order_rejected", *o)
+
+		return e.errorResponse(http.StatusBadRequest, "price_unavailable", fmt.Sprintf("No price of %s is known yet to check cash", o.Asset))
+	}
+	if req.Side == OrderSideBuy && req.Qty*price > e.cash {
+		o.Status = OrderStatusRejected
+		e.orders = append(e.orders, o)
+		e.addEvent("order_rejected", *o)
+
+		return e.errorResponse(http.StatusBadRequest, "insufficient_funds", "Not enough cash to place the order")
+	}
+
+	e.orders = append(e.orders, o)
+	e.addEvent("order_accepted", *o)
+
+	if bar := e.currentBar(o.Asset); bar != nil && e.fillTiming == FillSameBarClose {
+		e.matchOrder(o, bar)
+	}
+
+	return e.dataResponse(http.StatusCreated, *o)
+}
+
+// validateOrder returns an error if order request cannot be matched by Engine
+func (e *Engine) validateOrder(req *orderRequest) error {
+	if _, ok := e.bars[req.Asset]; !ok {
+		return fmt.Errorf("unknown asset %q", req.Asset)
+	}
+	if req.Side != OrderSideBuy && req.Side != OrderSideSell {
+		return fmt.Errorf("invalid side %q", req.Side)
+	}
+	if req.Qty <= 0 {
+		return errors.New("qty must be positive")
+	}
+
+	switch req.Type {
+	case OrderTypeMarket:
+	case OrderTypeLimit:
+		if req.LimitPrice == nil {
+			return errors.New("limit_price is required for limit orders")
+		}
+	case OrderTypeStop:
+		if req.StopPrice == nil {
+			return errors.New("stop_price is required for stop orders")
+		}
+	default:
+		return fmt.Errorf("unsupported order type %q", req.Type)
+	}
+	return nil
+}
+
+// estimatePrice returns expected execution price of the order used for cash validation,
+// or false if it's a market order of an asset without known price
+func (e *Engine) estimatePrice(o *Order) (float64, bool) {
+	switch o.Type {
+	case OrderTypeLimit:
+		return *o.LimitPrice, true
+	case OrderTypeStop:
+		return *o.StopPrice, true
+	}
+	price, ok := e.prices[o.Asset]
+	return price, ok
+}
+
+// getOrder returns order by ID
+func (e *Engine) getOrder(id string) *ErocResponse {
+	o := e.findOrder(id)
+	if o == nil {
+		return e.errorResponse(http.StatusNotFound, "order_not_found", fmt.Sprintf("Order %s not found", id))
+	}
+	return e.dataResponse(http.StatusOK, *o)
+}
+
+// cancelOrder cancels open order by ID
+func (e *Engine) cancelOrder(id string) *ErocResponse {
+	o := e.findOrder(id)
+	if o == nil {
+		return e.errorResponse(http.StatusNotFound, "order_not_found", fmt.Sprintf("Order %s not found", id))
+	}
+	if !o.isOpen() {
+		return e.errorResponse(http.StatusBadRequest, "invalid_order_status", fmt.Sprintf("Order %s is %s", id, o.Status))
+	}
+
+	canceledAt := e.now.Format(DatetimeLayout)
+	o.CanceledAt = &canceledAt
+	o.Status = OrderStatusCanceled
+	e.addEvent("order_canceled", *o)
+
+	return e.dataResponse(http.StatusOK, *o)
+}
+
+// findOrder returns order by ID or nil if it doesn't exist
+func (e *Engine) findOrder(id string) *Order {
+	for _, o := range e.orders {
+		if o.OrderID == id {
+			return o
+		}
+	}
+	return nil
+}
+
+// getBars returns bars of selected assets which are already known at the current Engine clock
+// using API shape: {datetime: {asset: {o, h, l, c, v}}}, resampled to the resolution if it is set
+func (e *Engine) getBars(query url.Values, location *time.Location) *ErocResponse {
+	assets := strings.Split(query.Get("assets"), ",")
+	if query.Get("assets") == "" {
+		assets = make([]string, 0, len(e.bars))
+		for asset := range e.bars {
+			assets = append(assets, asset)
+		}
+	}
+
+	var start, end time.Time
+	for key, dst := range map[string]*time.Time{"start": &start, "end": &end} {
+		if query.Get(key) == "" {
+			continue
+		}
+		dt, err := ParseDatetimeInLocation(query.Get(key), location)
+		if err != nil {
+			return e.errorResponse(http.StatusBadRequest, "invalid_datetime", err.Error())
+		}
+		*dst = dt
+	}
+
+	var step time.Duration
+	if query.Get("resolution") != "" {
+		var err error
+		if step, err = ParseResolution(query.Get("resolution")); err != nil {
+			return e.errorResponse(http.StatusBadRequest, "invalid_resolution", err.Error())
+		}
+	}
+
+	data := make(map[string]map[string]barJSON)
+	for _, asset := range assets {
+		bars := e.bars[asset][:e.cursor[asset]]
+		if step > 0 {
+			// Buckets end at the requested end like the Runner fetch window, the first one
+			// may start before the requested range and the incomplete last one is dropped
+			anchor := end
+			if anchor.IsZero() {
+				anchor = e.now
+			}
+			first := sort.Search(len(bars), func(i int) bool {
+				return bars[i].Datetime.After(start.Add(-step))
+			})
+			bars = Resample(bars[first:], step, anchor, e.now)
+		}
+
+		for _, bar := range bars {
+			if bar.Datetime.Before(start) || (!end.IsZero() && bar.Datetime.After(end)) {
+				continue
+			}
+			key := bar.Datetime.In(location).Format(BarsKeyLayout)
+			if _, ok := data[key]; !ok {
+				data[key] = make(map[string]barJSON)
+			}
+			data[key][asset] = barJSON{Open: bar.Open, High: bar.High, Low: bar.Low, Close: bar.Close, Volume: bar.Volume}
+		}
+	}
+
+	return e.dataResponse(http.StatusOK, data)
+}
+
+// account returns account information in API shape
+func (e *Engine) account() map[string]interface{} {
+	return map[string]interface{}{
+		"account_id":   AccountID,
+		"broker":       "tradologics",
+		"name":         "paper",
+		"cash":         e.cash,
+		"equity":       e.totalEquity(),
+		"buying_power": e.cash,
+		"currency":     e.currency,
+	}
+}
+
+// positionList returns open positions sorted by asset
+func (e *Engine) positionList() []Position {
+	positions := make([]Position, 0, len(e.positions))
+	for _, p := range e.positions {
+		if p.Qty == 0 {
+			continue
+		}
+		pos := *p
+		pos.MarketPrice = e.prices[p.Asset]
+		positions = append(positions, pos)
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i].Asset < positions[j].Asset
+	})
+	return positions
+}
+
+// orderList returns all orders in submission order
+func (e *Engine) orderList() []Order {
+	orders := make([]Order, len(e.orders))
+	for i, o := range e.orders {
+		orders[i] = *o
+	}
+	return orders
+}
+
+// totalEquity returns cash plus market value of all positions
+func (e *Engine) totalEquity() float64 {
+	equity := e.cash
+	for asset, p := range e.positions {
+		equity += p.Qty * e.prices[asset]
+	}
+	return equity
+}
+
+// addEvent appends event payload to runtime events of selected tradehook kind
+func (e *Engine) addEvent(kind string, payload interface{}) {
+	events, _ := e.events[kind].([]interface{})
+	e.events[kind] = append(events, payload)
+}
+
+// dataResponse returns successful EROC response with selected status and data
+func (e *Engine) dataResponse(status int, data interface{}) *ErocResponse {
+	return &ErocResponse{
+		Status: status,
+		Errors: []ErocError{},
+		Data:   data,
+	}
+}
+
+// errorResponse returns EROC response with a single error
+func (e *Engine) errorResponse(status int, id, message string) *ErocResponse {
+	return &ErocResponse{
+		Status: status,
+		Errors: []ErocError{{ID: id, Message: message}},
+		Data:   make(map[string]interface{}),
+	}
+}
+
+// ParseDatetime parse UTC datetime in EROC header or bars key format
+func ParseDatetime(value string) (time.Time, error) {
+	return ParseDatetimeInLocation(value, time.UTC)
+}
+
+// ParseDatetimeInLocation parse datetime in EROC header or bars key format using selected timezone
+func ParseDatetimeInLocation(value string, location *time.Location) (time.Time, error) {
+	for _, layout := range []string{DatetimeLayout, "2006-01-02 15:04:05", BarsKeyLayout, time.RFC3339Nano, "2006-01-02"} {
+		if dt, err := time.ParseInLocation(layout, value, location); err == nil {
+			return dt, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid datetime %q", value)
+}
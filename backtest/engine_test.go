@@ -0,0 +1,209 @@
+package backtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func testBars() map[string][]Bar {
+	day := func(d int) time.Time {
+		return time.Date(2021, 1, d, 21, 0, 0, 0, time.UTC)
+	}
+
+	return map[string][]Bar{
+		"AAPL": {
+			{Datetime: day(4), Open: 100, High: 105, Low: 99, Close: 104, Volume: 1000},
+			{Datetime: day(5), Open: 104, High: 108, Low: 103, Close: 107, Volume: 1000},
+			{Datetime: day(6), Open: 107, High: 107, Low: 95, Close: 96, Volume: 1000},
+			{Datetime: day(7), Open: 96, High: 101, Low: 94, Close: 100, Volume: 1000},
+		},
+	}
+}
+
+func erocRequest(method, url, datetime string, data ErocRequestData) *ErocRequest {
+	return &ErocRequest{
+		Method:  method,
+		Url:     url,
+		Data:    data,
+		Headers: ErocRequestHeader{Datetime: datetime, Resolution: "1day"},
+	}
+}
+
+func TestEngineMarketOrderFillsAtNextBarOpen(t *testing.T) {
+	engine := NewEngine(testBars(), DefaultCash)
+
+	res := engine.Handle(erocRequest(http.MethodPost, "/orders", "2021-01-04 21:00:00.000000", ErocRequestData{
+		"asset": "AAPL", "side": "buy", "type": "market", "qty": 10,
+	}))
+	assert.Equal(t, 201, res.Status)
+	assert.Equal(t, 0, len(res.Errors))
+	assert.Contains(t, res.Events, "order_accepted")
+
+	res = engine.Handle(erocRequest(http.MethodGet, "/positions", "2021-01-05 21:00:00.000000", nil))
+	assert.Equal(t, 200, res.Status)
+	assert.Contains(t, res.Events, "order_filled")
+
+	positions := res.Data.([]Position)
+	assert.Equal(t, 1, len(positions))
+	assert.Equal(t, 10.0, positions[0].Qty)
+	assert.Equal(t, 104.0, positions[0].AvgPrice)
+	assert.Equal(t, DefaultCash-1040.0, engine.Cash())
+
+	equity := engine.EquityCurve()
+	assert.Equal(t, 2, len(equity))
+	assert.Equal(t, DefaultCash-1040.0+1070.0, equity[1].Equity)
+}
+
+func TestEngineLimitAndStopOrders(t *testing.T) {
+	engine := NewEngine(testBars(), DefaultCash)
+
+	engine.Handle(erocRequest(http.MethodPost, "/orders", "2021-01-04 21:00:00.000000", ErocRequestData{
+		"asset": "AAPL", "side": "buy", "type": "limit", "qty": 10, "limit_price": 97,
+	}))
+	engine.Handle(erocRequest(http.MethodPost, "/orders", "2021-01-04 21:00:00.000000", ErocRequestData{
+		"asset": "AAPL", "side": "buy", "type": "stop", "qty": 5, "stop_price": 106,
+	}))
+
+	engine.Handle(erocRequest(http.MethodGet, "/orders", "2021-01-07 21:00:00.000000", nil))
+
+	fills := engine.Fills()
+	assert.Equal(t, 2, len(fills))
+	assert.Equal(t, 106.0, fills[0].Price)
+	assert.Equal(t, "2021-01-05", fills[0].Datetime.Format("2006-01-02"))
+	assert.Equal(t, 97.0, fills[1].Price)
+	assert.Equal(t, "2021-01-06", fills[1].Datetime.Format("2006-01-02"))
+}
+
+func TestEngineCancelOrder(t *testing.T) {
+	engine := NewEngine(testBars(), DefaultCash)
+
+	res := engine.Handle(erocRequest(http.MethodPost, "/orders", "2021-01-04 21:00:00.000000", ErocRequestData{
+		"asset": "AAPL", "side": "buy", "type": "limit", "qty": 10, "limit_price": 50,
+	}))
+	order := res.Data.(Order)
+
+	res = engine.Handle(erocRequest(http.MethodDelete, "/orders/"+order.OrderID, "2021-01-05 21:00:00.000000", nil))
+	assert.Equal(t, 200, res.Status)
+	assert.Equal(t, OrderStatusCanceled, res.Data.(Order).Status)
+	assert.Contains(t, res.Events, "order_canceled")
+
+	res = engine.Handle(erocRequest(http.MethodDelete, "/orders/"+order.OrderID, "2021-01-06 21:00:00.000000", nil))
+	assert.Equal(t, 400, res.Status)
+	assert.Equal(t, "invalid_order_status", res.Errors[0].ID)
+}
+
+func TestEngineInvalidOrderAndEndpoint(t *testing.T) {
+	engine := NewEngine(testBars(), DefaultCash)
+
+	res := engine.Handle(erocRequest(http.MethodPost, "/orders", "", ErocRequestData{
+		"asset": "MSFT", "side": "buy", "type": "market", "qty": 10,
+	}))
+	assert.Equal(t, 400, res.Status)
+	assert.Equal(t, "invalid_order", res.Errors[0].ID)
+
+	res = engine.Handle(erocRequest(http.MethodGet, "/com", "", nil))
+	assert.Equal(t, 502, res.Status)
+	assert.Equal(t, "Endpoint not found", res.Errors[0].Message)
+}
+
+func TestBacktestWithEngineTransport(t *testing.T) {
+	engine := NewEngine(testBars(), DefaultCash)
+	bt := NewBacktestWithTransport("2021-01-04 21:00:00.000000", "2021-01-07 21:00:00.000000", engine)
+	defer bt.Close()
+
+	bt.SetCurrentBarInfo(&BarInfo{Datetime: "2021-01-05 21:00:00.000000", Resolution: "1day"})
+
+	req, err := http.NewRequest(http.MethodGet, "/bars?assets=AAPL", nil)
+	assert.NoError(t, err)
+
+	res := bt.CallErocMethod(req)
+	assert.Equal(t, 200, res.StatusCode)
+
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+
+	var data BacktestResponse
+	assert.NoError(t, json.Unmarshal(body, &data))
+	assert.Equal(t, 2, len(data.Data.(map[string]interface{})))
+
+	order, err := json.Marshal(map[string]interface{}{"asset": "AAPL", "side": "sell", "type": "market", "qty": 1})
+	assert.NoError(t, err)
+
+	req, err = http.NewRequest(http.MethodPost, "/orders", bytes.NewBuffer(order))
+	assert.NoError(t, err)
+
+	res = bt.CallErocMethod(req)
+	assert.Equal(t, 201, res.StatusCode)
+	assert.Contains(t, bt.GetRuntimeEvents(), "order_accepted")
+}
+
+func TestResample(t *testing.T) {
+	bars := testBars()["AAPL"]
+
+	// 2021-01-04 is Monday, so every bar falls into the week ending next Monday
+	assert.Equal(t, []Bar{{
+		Datetime: time.Date(2021, 1, 11, 0, 0, 0, 0, time.UTC),
+		Open:     100, High: 108, Low: 94, Close: 100, Volume: 4000,
+	}}, Resample(bars, 7*24*time.Hour, time.Time{}, time.Time{}))
+
+	// Buckets are right-closed and end at the anchor, the incomplete one is dropped
+	resampled := Resample(bars, 48*time.Hour, bars[1].Datetime, bars[2].Datetime)
+	assert.Equal(t, []Bar{{
+		Datetime: bars[1].Datetime,
+		Open:     100, High: 108, Low: 99, Close: 107, Volume: 2000,
+	}}, resampled)
+
+	resampled = Resample(bars, 48*time.Hour, bars[1].Datetime, bars[3].Datetime)
+	assert.Equal(t, 2, len(resampled))
+	assert.Equal(t, bars[3].Datetime, resampled[1].Datetime)
+	assert.Equal(t, 2000.0, resampled[1].Volume)
+}
+
+func TestEngineResamplesCompleteBucketsOnly(t *testing.T) {
+	hour := func(h int) time.Time {
+		return time.Date(2021, 1, 4, h, 0, 0, 0, time.UTC)
+	}
+
+	var bars []Bar
+	for h := 1; h <= 24; h++ {
+		bars = append(bars, Bar{Datetime: hour(h), Open: 100, High: 101, Low: 99, Close: 100, Volume: 10})
+	}
+	engine := NewEngine(map[string][]Bar{"AAPL": bars}, DefaultCash)
+
+	request := func(datetime, query string) map[string]map[string]barJSON {
+		res := engine.Handle(erocRequest(http.MethodGet, "/bars?"+query, datetime, nil))
+		assert.Equal(t, 200, res.Status)
+		return res.Data.(map[string]map[string]barJSON)
+	}
+
+	// Bucket of 6 hours ending at 12:00 is complete, the one ending at 18:00 isn't
+	data := request("2021-01-04 14:00:00.000000", "assets=AAPL&resolution=6h&start=2021-01-04+00%3A00%3A00.000000&end=2021-01-04+18%3A00%3A00.000000")
+	assert.Equal(t, 2, len(data))
+	assert.Equal(t, 60.0, data["2021-01-04T06:00:00"]["AAPL"].Volume)
+	assert.Equal(t, 60.0, data["2021-01-04T12:00:00"]["AAPL"].Volume)
+
+	// Buckets end at the requested end, so each hour is counted once
+	data = request("2021-01-05 00:00:00.000000", "assets=AAPL&resolution=1day&start=2021-01-04+00%3A00%3A00.000001&end=2021-01-05+00%3A00%3A00.000000")
+	assert.Equal(t, 1, len(data))
+	assert.Equal(t, 240.0, data["2021-01-05T00:00:00"]["AAPL"].Volume)
+}
+
+func TestEngineRejectsMarketBuyWithoutPrice(t *testing.T) {
+	engine := NewEngine(testBars(), DefaultCash)
+
+	res := engine.Handle(erocRequest(http.MethodPost, "/orders", "2021-01-03 21:00:00.000000", ErocRequestData{
+		"asset": "AAPL", "side": "buy", "type": "market", "qty": 1000000,
+	}))
+	assert.Equal(t, 400, res.Status)
+	assert.Equal(t, "price_unavailable", res.Errors[0].ID)
+	assert.Contains(t, res.Events, "order_rejected")
+
+	engine.Handle(erocRequest(http.MethodGet, "/orders", "2021-01-05 21:00:00.000000", nil))
+	assert.Equal(t, 0, len(engine.Fills()))
+	assert.Equal(t, float64(DefaultCash), engine.Cash())
+}
@@ -0,0 +1,187 @@
+package backtest
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// EventType is a category of runtime event
+type EventType int
+
+const (
+	EventOther EventType = iota
+	EventOrder
+	EventFill
+	EventMonitor
+	EventError
+)
+
+// eventOrder is a delivery precedence of runtime event kinds received in the same response
+var eventOrder = []string{
+	"order_received",
+	"order_pending",
+	"order_submitted",
+	"order_sent",
+	"order_accepted",
+	"order_partially_filled",
+	"order_filled",
+	"order_pending_cancel",
+	"order_canceled",
+	"order_expired",
+	"order_rejected",
+	"price",
+	"price_expire",
+	"position",
+	"position_expire",
+	"error",
+}
+
+// RuntimeEvent is a single event emitted by the EROC router, delivered to the strategy as a tradehook
+type RuntimeEvent struct {
+	// Seq is a position of the event in the Backtest queue starting from 1
+	Seq uint64 `json:"seq"`
+
+	// Tradehook is an event kind, e.g. "order_filled" or "price"
+	Tradehook string `json:"event"`
+
+	// Data is a JSON-encoded tradehook payload
+	Data json.RawMessage `json:"data"`
+}
+
+// Type returns category of the event
+func (e RuntimeEvent) Type() EventType {
+	switch {
+	case e.Tradehook == "order_filled" || e.Tradehook == "order_partially_filled":
+		return EventFill
+	case strings.HasPrefix(e.Tradehook, "order"):
+		return EventOrder
+	case e.Tradehook == "error":
+		return EventError
+	case strings.HasPrefix(e.Tradehook, "price") || strings.HasPrefix(e.Tradehook, "position") ||
+		strings.HasPrefix(e.Tradehook, "monitor"):
+		return EventMonitor
+	}
+	return EventOther
+}
+
+// Order decodes order payload of order and fill events
+func (e RuntimeEvent) Order() (*Order, error) {
+	var order Order
+	if err := json.Unmarshal(e.Data, &order); err != nil {
+		return nil, err
+	}
+	return &order, nil
+}
+
+// Errors decodes payload of error events
+func (e RuntimeEvent) Errors() ([]ErocError, error) {
+	var payload struct {
+		Errors []ErocError `json:"errors"`
+	}
+	if err := json.Unmarshal(e.Data, &payload); err != nil {
+		return nil, err
+	}
+	return payload.Errors, nil
+}
+
+// Subscribe registers handler called with every new runtime event as soon as it's received;
+// events are still queued for Drain. Returns a function which removes the handler
+func (b *Backtest) Subscribe(handler func(event RuntimeEvent)) func() {
+	b.eventsMu.Lock()
+	defer b.eventsMu.Unlock()
+
+	b.nextSubscriberID++
+	id := b.nextSubscriberID
+	b.subscribers[id] = handler
+
+	return func() {
+		b.eventsMu.Lock()
+		defer b.eventsMu.Unlock()
+
+		delete(b.subscribers, id)
+	}
+}
+
+// Drain returns all queued runtime events and empties the queue. Events are ordered by the response
+// they were received in; EROC response groups events by kind, so events of a single response are ordered
+// by kind precedence, e.g. "order_accepted" before "order_filled", and by emission within the same kind
+func (b *Backtest) Drain() []RuntimeEvent {
+	b.eventsMu.Lock()
+	defer b.eventsMu.Unlock()
+
+	events := b.eventQueue
+	b.eventQueue = nil
+	return events
+}
+
+// queueEvents appends events of EROC response to the queue and notifies subscribers
+func (b *Backtest) queueEvents(events RuntimeEvents) {
+	if len(events) == 0 {
+		return
+	}
+
+	b.eventsMu.Lock()
+	queued := make([]RuntimeEvent, 0, len(events))
+	for _, kind := range sortedEventKinds(events) {
+		payloads, ok := events[kind].([]interface{})
+		if !ok {
+			payloads = []interface{}{events[kind]}
+		}
+
+		for _, p := range payloads {
+			data, err := json.Marshal(p)
+			if err != nil {
+				continue
+			}
+
+			b.eventSeq++
+			queued = append(queued, RuntimeEvent{Seq: b.eventSeq, Tradehook: kind, Data: data})
+		}
+	}
+	b.eventQueue = append(b.eventQueue, queued...)
+
+	subscribers := make([]func(RuntimeEvent), 0, len(b.subscribers))
+	ids := make([]uint64, 0, len(b.subscribers))
+	for id := range b.subscribers {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return ids[i] < ids[j]
+	})
+	for _, id := range ids {
+		subscribers = append(subscribers, b.subscribers[id])
+	}
+	b.eventsMu.Unlock()
+
+	// Handlers are called without the lock, so they can send new requests
+	for _, event := range queued {
+		for _, handler := range subscribers {
+			handler(event)
+		}
+	}
+}
+
+// sortedEventKinds returns event kinds in delivery order, unknown kinds are delivered last
+func sortedEventKinds(events RuntimeEvents) []string {
+	rank := func(kind string) int {
+		for i, k := range eventOrder {
+			if k == kind {
+				return i
+			}
+		}
+		return len(eventOrder)
+	}
+
+	kinds := make([]string, 0, len(events))
+	for kind := range events {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		if rank(kinds[i]) != rank(kinds[j]) {
+			return rank(kinds[i]) < rank(kinds[j])
+		}
+		return kinds[i] < kinds[j]
+	})
+	return kinds
+}
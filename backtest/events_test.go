@@ -0,0 +1,96 @@
+package backtest
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestRuntimeEventType(t *testing.T) {
+	assert.Equal(t, EventFill, RuntimeEvent{Tradehook: "order_filled"}.Type())
+	assert.Equal(t, EventFill, RuntimeEvent{Tradehook: "order_partially_filled"}.Type())
+	assert.Equal(t, EventOrder, RuntimeEvent{Tradehook: "order_canceled"}.Type())
+	assert.Equal(t, EventMonitor, RuntimeEvent{Tradehook: "price_expire"}.Type())
+	assert.Equal(t, EventMonitor, RuntimeEvent{Tradehook: "position"}.Type())
+	assert.Equal(t, EventError, RuntimeEvent{Tradehook: "error"}.Type())
+	assert.Equal(t, EventOther, RuntimeEvent{Tradehook: "bar"}.Type())
+
+	errs, err := RuntimeEvent{Tradehook: "error", Data: []byte(`{"errors":[{"id":"foo","message":"boo"}]}`)}.Errors()
+	assert.NoError(t, err)
+	assert.Equal(t, []ErocError{{ID: "foo", Message: "boo"}}, errs)
+}
+
+func TestRuntimeEventsAreQueuedAcrossRequests(t *testing.T) {
+	bt := NewBacktestWithTransport("2021-01-04 21:00:00.000000", "2021-01-07 21:00:00.000000", NewEngine(testBars(), DefaultCash))
+
+	var received []string
+	unsubscribe := bt.Subscribe(func(event RuntimeEvent) {
+		received = append(received, event.Tradehook)
+	})
+
+	bt.SetCurrentBarInfo(&BarInfo{Datetime: "2021-01-04 21:00:00.000000", Resolution: "1day"})
+	assert.Equal(t, 201, postOrder(t, bt, map[string]interface{}{"asset": "AAPL", "side": "buy", "type": "market", "qty": 1}).StatusCode)
+	assert.Equal(t, 201, postOrder(t, bt, map[string]interface{}{"asset": "AAPL", "side": "buy", "type": "limit", "qty": 1, "limit_price": 1}).StatusCode)
+
+	bt.SetCurrentBarInfo(&BarInfo{Datetime: "2021-01-05 21:00:00.000000", Resolution: "1day"})
+	req, err := http.NewRequest(http.MethodGet, "/positions", nil)
+	assert.NoError(t, err)
+	bt.CallErocMethod(req)
+
+	// The last response only has the fill, but nothing is lost in the queue
+	assert.Equal(t, 1, len(bt.GetRuntimeEvents()))
+
+	events := bt.Drain()
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, []string{"order_accepted", "order_accepted", "order_filled"}, received)
+	for i, event := range events {
+		assert.Equal(t, uint64(i+1), event.Seq)
+		assert.Equal(t, received[i], event.Tradehook)
+	}
+
+	order, err := events[2].Order()
+	assert.NoError(t, err)
+	assert.Equal(t, OrderStatusFilled, order.Status)
+	assert.Equal(t, 0, len(bt.Drain()))
+
+	limit, err := events[1].Order()
+	assert.NoError(t, err)
+
+	unsubscribe()
+	req, err = http.NewRequest(http.MethodDelete, "/orders/"+limit.OrderID, nil)
+	assert.NoError(t, err)
+	bt.CallErocMethod(req)
+
+	assert.Equal(t, 3, len(received))
+	assert.Equal(t, 1, len(bt.Drain()))
+}
+
+func TestRuntimeEventsOfOneResponseAreOrderedByKind(t *testing.T) {
+	bt := NewBacktestWithTransport("2021-01-04 21:00:00.000000", "2021-01-07 21:00:00.000000", &recordingTransport{})
+
+	bt.queueEvents(RuntimeEvents{
+		"custom":         map[string]interface{}{"id": "x"},
+		"order_filled":   []interface{}{map[string]interface{}{"id": "a"}, map[string]interface{}{"id": "b"}},
+		"error":          map[string]interface{}{"id": "e"},
+		"order_accepted": []interface{}{map[string]interface{}{"id": "c"}, map[string]interface{}{"id": "d"}},
+	})
+	bt.queueEvents(RuntimeEvents{
+		"order_accepted": []interface{}{map[string]interface{}{"id": "f"}},
+	})
+
+	var kinds, ids []string
+	for i, event := range bt.Drain() {
+		assert.Equal(t, uint64(i+1), event.Seq)
+
+		var payload struct {
+			ID string `json:"id"`
+		}
+		assert.NoError(t, json.Unmarshal(event.Data, &payload))
+		kinds = append(kinds, event.Tradehook)
+		ids = append(ids, payload.ID)
+	}
+
+	assert.Equal(t, []string{"order_accepted", "order_accepted", "order_filled", "order_filled", "error", "custom", "order_accepted"}, kinds)
+	assert.Equal(t, []string{"c", "d", "a", "b", "e", "x", "f"}, ids)
+}
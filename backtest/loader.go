@@ -0,0 +1,387 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ColumnMapping names file columns holding bar fields, column names are case-insensitive
+type ColumnMapping struct {
+	Datetime string
+	Asset    string
+	Open     string
+	High     string
+	Low      string
+	Close    string
+	Volume   string
+}
+
+// DefaultColumns is a column mapping used by file sources by default
+var DefaultColumns = ColumnMapping{
+	Datetime: "datetime",
+	Asset:    "asset",
+	Open:     "open",
+	High:     "high",
+	Low:      "low",
+	Close:    "close",
+	Volume:   "volume",
+}
+
+// RecordReader reads file rows as string fields, the first row is a header. *csv.Reader implements it
+type RecordReader interface {
+	Read() ([]string, error)
+}
+
+// RecordDecoder opens rows of a binary file as a RecordReader, e.g. Decode of the backtest/parquet package
+type RecordDecoder func(file io.ReaderAt, size int64) (RecordReader, error)
+
+// FileOption configures FileSource
+type FileOption func(*FileSource)
+
+// WithColumns sets column mapping, DefaultColumns is used by default
+func WithColumns(columns ColumnMapping) FileOption {
+	return func(s *FileSource) {
+		s.columns = columns
+	}
+}
+
+// WithFileLocation sets timezone of datetimes without one, UTC is used by default
+func WithFileLocation(location *time.Location) FileOption {
+	return func(s *FileSource) {
+		s.location = location
+	}
+}
+
+// WithDatetimeLayout sets layout of the datetime column; by default the layouts accepted by ParseDatetime,
+// "YYYYMMDD" dates and unix timestamps in seconds or milliseconds are recognized
+func WithDatetimeLayout(layout string) FileOption {
+	return func(s *FileSource) {
+		s.layout = layout
+	}
+}
+
+// WithFileResolution resamples file bars to selected resolution, resampled bars are dated by the end of their bucket.
+// Buckets are aligned to midnight of the file location, e.g. daily bars are cut at midnight of WithFileLocation
+func WithFileResolution(resolution string) FileOption {
+	return func(s *FileSource) {
+		s.resolution = resolution
+	}
+}
+
+// WithAsset sets asset of files without asset column, file name without extension is used by default
+func WithAsset(asset string) FileOption {
+	return func(s *FileSource) {
+		s.asset = asset
+	}
+}
+
+// FileSource is a DataSource of bars read from CSV files or files decoded by a RecordDecoder
+type FileSource struct {
+	paths      []string
+	open       func(path string) (RecordReader, io.Closer, error)
+	columns    ColumnMapping
+	location   *time.Location
+	layout     string
+	resolution string
+	step       time.Duration
+	asset      string
+
+	mu   sync.Mutex
+	bars map[string][]Bar
+}
+
+// NewCSVSource create new FileSource of CSV files matching selected path or glob pattern,
+// e.g. "data/*.csv" for a file per asset
+func NewCSVSource(path string, opts ...FileOption) (*FileSource, error) {
+	return newFileSource(path, func(path string) (RecordReader, io.Closer, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		reader := csv.NewReader(file)
+		reader.ReuseRecord = true
+		return reader, file, nil
+	}, opts)
+}
+
+// NewDecodedSource create new FileSource of files matching selected path or glob pattern, which rows
+// are read with selected decoder. It's a hook for binary formats, Parquet files are read by
+// NewSource of the backtest/parquet package
+func NewDecodedSource(path string, decoder RecordDecoder, opts ...FileOption) (*FileSource, error) {
+	if decoder == nil {
+		return nil, errors.New("record decoder is required")
+	}
+
+	return newFileSource(path, func(path string) (RecordReader, io.Closer, error) {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+
+		reader, err := decoder(file, info.Size())
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		return reader, file, nil
+	}, opts)
+}
+
+// newFileSource create new FileSource of files matching the pattern and validates its options
+func newFileSource(pattern string, open func(path string) (RecordReader, io.Closer, error), opts []FileOption) (*FileSource, error) {
+	paths, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no files match %q", pattern)
+	}
+
+	s := &FileSource{
+		paths:    paths,
+		open:     open,
+		columns:  DefaultColumns,
+		location: time.UTC,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.location == nil {
+		return nil, errors.New("location is required")
+	}
+	if s.columns.Datetime == "" || s.columns.Close == "" {
+		return nil, errors.New("datetime and close columns are required")
+	}
+	if s.resolution != "" {
+		if s.step, err = ParseResolution(s.resolution); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Bars returns bars of every asset between start and end inclusive sorted by datetime. Files are read
+// by the first call and their bars are kept in memory for the next ones
+func (s *FileSource) Bars(start, end time.Time) (map[string][]Bar, error) {
+	all, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	bars := make(map[string][]Bar)
+	for asset, assetBars := range all {
+		var filtered []Bar
+		for _, bar := range assetBars {
+			if !bar.Datetime.Before(start) && !bar.Datetime.After(end) {
+				filtered = append(filtered, bar)
+			}
+		}
+		if len(filtered) > 0 {
+			bars[asset] = filtered
+		}
+	}
+	return bars, nil
+}
+
+// load reads, sorts and resamples bars of every file once
+func (s *FileSource) load() (map[string][]Bar, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.bars != nil {
+		return s.bars, nil
+	}
+
+	bars := make(map[string][]Bar)
+	for _, path := range s.paths {
+		if err := s.readFile(path, bars); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+
+	for asset, assetBars := range bars {
+		sort.SliceStable(assetBars, func(i, j int) bool {
+			return assetBars[i].Datetime.Before(assetBars[j].Datetime)
+		})
+		if s.step > 0 {
+			bars[asset] = s.resample(assetBars)
+		}
+	}
+
+	s.bars = bars
+	return bars, nil
+}
+
+// resample aggregates bars by the wall clock of the file location, so buckets are aligned to its midnight
+// also on days when daylight saving time changes
+func (s *FileSource) resample(bars []Bar) []Bar {
+	wall := make([]Bar, len(bars))
+	for i, bar := range bars {
+		bar.Datetime = wallClock(bar.Datetime.In(s.location), time.UTC)
+		wall[i] = bar
+	}
+
+	resampled := Resample(wall, s.step, time.Time{}, time.Time{})
+	for i := range resampled {
+		resampled[i].Datetime = wallClock(resampled[i].Datetime, s.location)
+	}
+	return resampled
+}
+
+// wallClock returns the same date and time of day in selected location
+func wallClock(dt time.Time, location *time.Location) time.Time {
+	return time.Date(dt.Year(), dt.Month(), dt.Day(), dt.Hour(), dt.Minute(), dt.Second(), dt.Nanosecond(), location)
+}
+
+// readFile appends bars of the file to the map
+func (s *FileSource) readFile(path string, bars map[string][]Bar) error {
+	reader, closer, err := s.open(path)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	header, err := reader.Read()
+	if err != nil {
+		return err
+	}
+	// Readers may reuse the record slice
+	header = append([]string(nil), header...)
+
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		index[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	column := func(name string) int {
+		if i, ok := index[strings.ToLower(name)]; ok && name != "" {
+			return i
+		}
+		return -1
+	}
+
+	datetimeCol, assetCol := column(s.columns.Datetime), column(s.columns.Asset)
+	if datetimeCol < 0 {
+		return fmt.Errorf("datetime column %q not found", s.columns.Datetime)
+	}
+	priceCols := []int{column(s.columns.Open), column(s.columns.High), column(s.columns.Low), column(s.columns.Close), column(s.columns.Volume)}
+	if priceCols[3] < 0 {
+		return fmt.Errorf("close column %q not found", s.columns.Close)
+	}
+
+	asset := s.asset
+	if asset == "" && assetCol < 0 {
+		asset = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if len(record) < len(header) {
+			return fmt.Errorf("line %d: expected %d fields, got %d", line, len(header), len(record))
+		}
+
+		dt, err := s.parseDatetime(record[datetimeCol])
+		if err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+
+		var values [5]float64
+		for i, col := range priceCols {
+			if col < 0 || strings.TrimSpace(record[col]) == "" {
+				values[i] = math.NaN()
+				continue
+			}
+			if values[i], err = strconv.ParseFloat(strings.TrimSpace(record[col]), 64); err != nil {
+				return fmt.Errorf("line %d: invalid %s: %v", line, header[col], err)
+			}
+		}
+
+		// Missing open, high and low fall back to close and missing volume to zero
+		bar := Bar{Datetime: dt, Open: values[0], High: values[1], Low: values[2], Close: values[3], Volume: values[4]}
+		for _, price := range []*float64{&bar.Open, &bar.High, &bar.Low} {
+			if math.IsNaN(*price) {
+				*price = bar.Close
+			}
+		}
+		if math.IsNaN(bar.Volume) {
+			bar.Volume = 0
+		}
+
+		barAsset := asset
+		if assetCol >= 0 {
+			barAsset = record[assetCol]
+		}
+		bars[barAsset] = append(bars[barAsset], bar)
+	}
+}
+
+// parseDatetime parses datetime of a row using the layout, known layouts, "YYYYMMDD" date or unix timestamp
+func (s *FileSource) parseDatetime(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if s.layout != "" {
+		return time.ParseInLocation(s.layout, value, s.location)
+	}
+
+	// Integer of 8 digits is a date rather than a timestamp of 1970s
+	if dt, err := time.ParseInLocation("20060102", value, s.location); err == nil {
+		return dt, nil
+	}
+	if ts, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if ts > 1e12 {
+			return time.UnixMilli(ts).In(s.location), nil
+		}
+		return time.Unix(ts, 0).In(s.location), nil
+	}
+	return ParseDatetimeInLocation(value, s.location)
+}
+
+// BarsPayload converts bars to the shape returned by the bars API: {datetime: {asset: {o, h, l, c, v}}},
+// so they can be parsed with helpers.ParseBars
+func BarsPayload(bars map[string][]Bar, location *time.Location) map[string]interface{} {
+	if location == nil {
+		location = time.UTC
+	}
+
+	payload := make(map[string]interface{})
+	for asset, assetBars := range bars {
+		for _, bar := range assetBars {
+			key := bar.Datetime.In(location).Format(BarsKeyLayout)
+			if _, ok := payload[key]; !ok {
+				payload[key] = make(map[string]interface{})
+			}
+			payload[key].(map[string]interface{})[asset] = map[string]interface{}{
+				"o": bar.Open,
+				"h": bar.High,
+				"l": bar.Low,
+				"c": bar.Close,
+				"v": bar.Volume,
+			}
+		}
+	}
+	return payload
+}
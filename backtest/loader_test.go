@@ -0,0 +1,155 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"github.com/stretchr/testify/assert"
+	"github.com/tradologics/go-sdk/helpers"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func TestCSVSourceReadsFilePerAsset(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "AAPL.csv", "Date,Open,High,Low,Close,Volume\n"+
+		"2021-01-05,104,108,103,107,1000\n"+
+		"2021-01-04,100,105,99,104,1000\n")
+	writeFile(t, dir, "MSFT.csv", "Date,Open,High,Low,Close,Volume\n"+
+		"2021-01-04,200,205,199,204,2000\n")
+
+	location, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+
+	source, err := NewCSVSource(filepath.Join(dir, "*.csv"),
+		WithColumns(ColumnMapping{Datetime: "date", Open: "open", High: "high", Low: "low", Close: "close", Volume: "volume"}),
+		WithFileLocation(location),
+	)
+	assert.NoError(t, err)
+
+	bars, err := source.Bars(time.Date(2021, 1, 1, 0, 0, 0, 0, location), time.Date(2021, 1, 31, 0, 0, 0, 0, location))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(bars["AAPL"]))
+	assert.Equal(t, 1, len(bars["MSFT"]))
+
+	// Bars are sorted by datetime in the file timezone
+	assert.Equal(t, time.Date(2021, 1, 4, 5, 0, 0, 0, time.UTC), bars["AAPL"][0].Datetime.UTC())
+	assert.Equal(t, 107.0, bars["AAPL"][1].Close)
+
+	// Bars are filtered by range
+	bars, err = source.Bars(time.Date(2021, 1, 5, 0, 0, 0, 0, location), time.Date(2021, 1, 31, 0, 0, 0, 0, location))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(bars["AAPL"]))
+	assert.NotContains(t, bars, "MSFT")
+}
+
+func TestCSVSourceReadsAssetColumnAndResamples(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "bars.csv", "datetime,asset,close,volume\n"+
+		"1609750800,AAPL,100,10\n"+
+		"1609754400,AAPL,102,10\n"+
+		"1609754400000,MSFT,200,\n")
+
+	source, err := NewCSVSource(path, WithFileResolution("1day"))
+	assert.NoError(t, err)
+
+	bars, err := source.Bars(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, []Bar{{
+		Datetime: time.Date(2021, 1, 5, 0, 0, 0, 0, time.UTC),
+		Open:     100, High: 102, Low: 100, Close: 102, Volume: 20,
+	}}, bars["AAPL"])
+	assert.Equal(t, 0.0, bars["MSFT"][0].Volume)
+	assert.Equal(t, 200.0, bars["MSFT"][0].Open)
+}
+
+func TestCSVSourceResamplesInFileLocation(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "AAPL.csv", "datetime,close\n"+
+		"2021-01-04 15:00:00,100\n"+
+		"2021-01-04 19:30:00,101\n"+
+		"2021-01-05 10:00:00,102\n")
+
+	location, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+
+	source, err := NewCSVSource(path, WithFileLocation(location), WithFileResolution("1day"))
+	assert.NoError(t, err)
+
+	// Evening bar belongs to the day of the file location, not to the next UTC day
+	bars, err := source.Bars(time.Date(2021, 1, 1, 0, 0, 0, 0, location), time.Date(2021, 1, 31, 0, 0, 0, 0, location))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(bars["AAPL"]))
+	assert.Equal(t, time.Date(2021, 1, 5, 0, 0, 0, 0, location), bars["AAPL"][0].Datetime)
+	assert.Equal(t, 101.0, bars["AAPL"][0].Close)
+	assert.Equal(t, time.Date(2021, 1, 6, 0, 0, 0, 0, location), bars["AAPL"][1].Datetime)
+
+	// File is read once
+	assert.NoError(t, os.Remove(path))
+	bars, err = source.Bars(time.Date(2021, 1, 6, 0, 0, 0, 0, location), time.Date(2021, 1, 31, 0, 0, 0, 0, location))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(bars["AAPL"]))
+	assert.Equal(t, 102.0, bars["AAPL"][0].Close)
+}
+
+func TestCSVSourceReadsCompactDates(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "AAPL.csv", "datetime,close\n20210104,104\n20210105,107\n")
+
+	source, err := NewCSVSource(path)
+	assert.NoError(t, err)
+
+	bars, err := source.Bars(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(bars["AAPL"]))
+	assert.Equal(t, time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC), bars["AAPL"][0].Datetime)
+	assert.Equal(t, time.Date(2021, 1, 5, 0, 0, 0, 0, time.UTC), bars["AAPL"][1].Datetime)
+}
+
+func TestCSVSourceErrors(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := NewCSVSource(filepath.Join(dir, "*.csv"))
+	assert.Error(t, err)
+
+	path := writeFile(t, dir, "AAPL.csv", "datetime,close\nfoo,100\n")
+	_, err = NewCSVSource(path, WithFileResolution("1y"))
+	assert.Error(t, err)
+
+	source, err := NewCSVSource(path)
+	assert.NoError(t, err)
+	_, err = source.Bars(time.Time{}, time.Now())
+	assert.EqualError(t, err, path+": line 2: invalid datetime \"foo\"")
+}
+
+func TestDecodedSourceUsesDecoder(t *testing.T) {
+	path := writeFile(t, t.TempDir(), "AAPL.parquet", "datetime,close\n2021-01-04 21:00:00,104\n")
+
+	// Test decoder reads CSV content, real one decodes Parquet columns
+	decoder := func(file io.ReaderAt, size int64) (RecordReader, error) {
+		return csv.NewReader(io.NewSectionReader(file, 0, size)), nil
+	}
+
+	_, err := NewDecodedSource(path, nil)
+	assert.Error(t, err)
+
+	source, err := NewDecodedSource(path, decoder)
+	assert.NoError(t, err)
+
+	bars, err := source.Bars(time.Time{}, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, 104.0, bars["AAPL"][0].Close)
+}
+
+func TestBarsPayloadIsParsedByHelpers(t *testing.T) {
+	payload := BarsPayload(testBars(), time.UTC)
+	assert.Contains(t, payload, "2021-01-04T21:00:00")
+
+	parsed := helpers.ParseBars(&payload)
+	assert.Equal(t, []float64{104, 107, 96, 100}, parsed["AAPL"]["c"])
+	assert.Equal(t, 4, len(parsed["AAPL"]["dt"]))
+}
@@ -0,0 +1,260 @@
+package backtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"gopkg.in/zeromq/goczmq.v4"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// mockPollTimeout is a period in milliseconds MockRouter checks whether it was closed
+const mockPollTimeout = 100
+
+// MockHandler returns EROC response for the request received by MockRouter
+type MockHandler func(req *ErocRequest) *ErocResponse
+
+// MockRouter is an EROC router for unit testing strategies in backtest mode. It listens on selected
+// endpoint, answers requests using handlers registered per method and URL path, and records requests
+// and header mismatches, which can be checked with Verify
+type MockRouter struct {
+	mu       sync.Mutex
+	handlers map[string]MockHandler
+	header   *ErocRequestHeader
+	requests []ErocRequest
+	errors   []error
+
+	sock      *goczmq.Sock
+	poller    *goczmq.Poller
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewMockRouter create new MockRouter bound to selected endpoint, e.g. "tcp://*:3003",
+// and start serving requests
+func NewMockRouter(endpoint string) (*MockRouter, error) {
+	sock, err := goczmq.NewRouter(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	poller, err := goczmq.NewPoller(sock)
+	if err != nil {
+		sock.Destroy()
+		return nil, err
+	}
+
+	m := &MockRouter{
+		handlers: make(map[string]MockHandler),
+		sock:     sock,
+		poller:   poller,
+		done:     make(chan struct{}),
+	}
+
+	m.wg.Add(1)
+	go m.serve()
+
+	return m, nil
+}
+
+// Handle registers handler of requests with selected method and URL path, query is ignored
+func (m *MockRouter) Handle(method, path string, handler MockHandler) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.handlers[mockKey(method, path)] = handler
+}
+
+// Respond registers canned response of requests with selected method and URL path
+func (m *MockRouter) Respond(method, path string, res *ErocResponse) {
+	m.Handle(method, path, func(req *ErocRequest) *ErocResponse {
+		return res
+	})
+}
+
+// ExpectHeaders sets header values every request must have, empty fields are not checked
+func (m *MockRouter) ExpectHeaders(header ErocRequestHeader) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.header = &header
+}
+
+// Requests returns all received requests
+func (m *MockRouter) Requests() []ErocRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	requests := make([]ErocRequest, len(m.requests))
+	copy(requests, m.requests)
+	return requests
+}
+
+// Errors returns header mismatches, invalid and unhandled requests
+func (m *MockRouter) Errors() []error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	errs := make([]error, len(m.errors))
+	copy(errs, m.errors)
+	return errs
+}
+
+// TestingT is an interface wrapper around *testing.T
+type TestingT interface {
+	Errorf(format string, args ...interface{})
+}
+
+// Verify reports every error of the router to the test
+func (m *MockRouter) Verify(t TestingT) {
+	for _, err := range m.Errors() {
+		t.Errorf("mock EROC router: %v", err)
+	}
+}
+
+// Close stops serving requests and releases the socket, it can be called more than once
+func (m *MockRouter) Close() {
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.wg.Wait()
+
+		m.poller.Destroy()
+		m.sock.Destroy()
+	})
+}
+
+// serve answers requests until the router is closed
+func (m *MockRouter) serve() {
+	defer m.wg.Done()
+
+	for {
+		select {
+		case <-m.done:
+			return
+		default:
+		}
+
+		sock := m.poller.Wait(mockPollTimeout)
+		if sock == nil {
+			continue
+		}
+
+		msg, err := sock.RecvMessage()
+		if err != nil || len(msg) < 2 {
+			continue
+		}
+
+		// Router message is an identity frame, optional empty delimiter and the payload
+		res, err := json.Marshal(m.handle(msg[len(msg)-1]))
+		if err != nil {
+			m.addError(err)
+			continue
+		}
+
+		reply := append(msg[:len(msg)-1:len(msg)-1], res)
+		if err = sock.SendMessage(reply); err != nil {
+			m.addError(err)
+		}
+	}
+}
+
+// handle decodes request, checks its headers and returns handler response
+func (m *MockRouter) handle(payload []byte) *ErocResponse {
+	var req ErocRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		m.addError(fmt.Errorf("invalid request: %v", err))
+		return mockError(http.StatusBadGateway, "Invalid JSON")
+	}
+
+	m.mu.Lock()
+	m.requests = append(m.requests, req)
+	if m.header != nil {
+		for _, err := range compareHeaders(m.header, &req.Headers) {
+			m.errors = append(m.errors, fmt.Errorf("%s %s: %v", req.Method, req.Url, err))
+		}
+	}
+
+	path := req.Url
+	if u, err := url.Parse(req.Url); err == nil {
+		path = u.Path
+	}
+	handler, ok := m.handlers[mockKey(req.Method, path)]
+	if !ok {
+		m.errors = append(m.errors, fmt.Errorf("unhandled request %s %s", req.Method, req.Url))
+	}
+	m.mu.Unlock()
+
+	if !ok {
+		return mockError(http.StatusBadGateway, "Endpoint not found")
+	}
+
+	res := m.call(handler, &req)
+	if res == nil {
+		// Handler without response answers with empty success
+		res = &ErocResponse{Status: http.StatusOK, Data: make(map[string]interface{})}
+	}
+	if res.Errors == nil {
+		// Handlers can share a response between requests, so it's copied instead of modified
+		normalized := *res
+		normalized.Errors = []ErocError{}
+		res = &normalized
+	}
+	return res
+}
+
+// call returns handler response, handler panic is recorded and answered with 500, so the router keeps serving
+func (m *MockRouter) call(handler MockHandler, req *ErocRequest) (res *ErocResponse) {
+	defer func() {
+		if p := recover(); p != nil {
+			m.addError(fmt.Errorf("%s %s: handler panic: %v", req.Method, req.Url, p))
+			res = mockError(http.StatusInternalServerError, "Handler panic")
+		}
+	}()
+
+	return handler(req)
+}
+
+// addError records router error
+func (m *MockRouter) addError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.errors = append(m.errors, err)
+}
+
+// compareHeaders returns mismatches of non-empty expected header fields
+func compareHeaders(expected, actual *ErocRequestHeader) []error {
+	var errs []error
+	check := func(name, expected, actual string) {
+		if expected != "" && expected != actual {
+			errs = append(errs, fmt.Errorf("header %s is %q, expected %q", name, actual, expected))
+		}
+	}
+
+	check("start", expected.Start, actual.Start)
+	check("end", expected.End, actual.End)
+	check("datetime", expected.Datetime, actual.Datetime)
+	check("resolution", expected.Resolution, actual.Resolution)
+	check("timezone", expected.Timezone, actual.Timezone)
+	check("currency", expected.Currency, actual.Currency)
+	if expected.Capital != 0 && expected.Capital != actual.Capital {
+		errs = append(errs, fmt.Errorf("header capital is %v, expected %v", actual.Capital, expected.Capital))
+	}
+	return errs
+}
+
+// mockKey returns handler key of the method and URL path
+func mockKey(method, path string) string {
+	return method + " " + path
+}
+
+// mockError returns EROC response with internal server error
+func mockError(status int, message string) *ErocResponse {
+	return &ErocResponse{
+		Status: status,
+		Errors: []ErocError{{ID: "internal_server_error", Message: message}},
+		Data:   make(map[string]interface{}),
+	}
+}
@@ -0,0 +1,102 @@
+package backtest
+
+import (
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestMockRouterServesBacktest(t *testing.T) {
+	router, err := NewMockRouter("tcp://*:3008")
+	assert.NoError(t, err)
+	defer router.Close()
+
+	router.ExpectHeaders(ErocRequestHeader{
+		Start:      "2021-01-04 21:00:00.000000",
+		End:        "2021-01-07 21:00:00.000000",
+		Resolution: "1day",
+	})
+	router.Handle(http.MethodPost, "/orders", func(req *ErocRequest) *ErocResponse {
+		return &ErocResponse{
+			Status: 201,
+			Data:   map[string]interface{}{"order_id": "foo", "asset": req.Data["asset"]},
+			Events: RuntimeEvents{"order_filled": []interface{}{map[string]interface{}{"order_id": "foo"}}},
+		}
+	})
+
+	bt, err := NewBacktest("2021-01-04 21:00:00.000000", "2021-01-07 21:00:00.000000", "tcp://127.0.0.1:3008")
+	assert.NoError(t, err)
+	defer bt.Close()
+
+	bt.SetCurrentBarInfo(&BarInfo{Datetime: "2021-01-05 21:00:00.000000", Resolution: "1day"})
+
+	res := postOrder(t, bt, map[string]interface{}{"asset": "AAPL", "side": "buy", "type": "market", "qty": 1})
+	assert.Equal(t, 201, res.StatusCode)
+
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"errors":[],"data":{"asset":"AAPL","order_id":"foo"}}`, string(body))
+
+	events := bt.Drain()
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "order_filled", events[0].Tradehook)
+
+	requests := router.Requests()
+	assert.Equal(t, 1, len(requests))
+	assert.Equal(t, "2021-01-05 21:00:00.000000", requests[0].Data["datetime"])
+	router.Verify(t)
+
+	// Unhandled requests are answered like by the EROC router and reported
+	req, err := http.NewRequest(http.MethodGet, "/com", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 502, bt.CallErocMethod(req).StatusCode)
+	assert.Equal(t, 1, len(router.Errors()))
+
+	// Deferred Close is safe after the router is closed
+	router.Close()
+}
+
+func TestMockRouterAnswersNilResponseWithEmptySuccess(t *testing.T) {
+	router := &MockRouter{handlers: make(map[string]MockHandler)}
+	router.Handle(http.MethodDelete, "/orders/foo", func(req *ErocRequest) *ErocResponse {
+		return nil
+	})
+
+	res := router.handle([]byte(`{"method":"DELETE","url":"/orders/foo?force=true"}`))
+	assert.Equal(t, http.StatusOK, res.Status)
+	assert.Equal(t, []ErocError{}, res.Errors)
+	assert.Equal(t, map[string]interface{}{}, res.Data)
+	assert.Empty(t, router.Errors())
+}
+
+func TestMockRouterDoesNotModifyCannedResponse(t *testing.T) {
+	router := &MockRouter{handlers: make(map[string]MockHandler)}
+	canned := &ErocResponse{Status: http.StatusOK, Data: map[string]interface{}{"id": "foo"}}
+	router.Respond(http.MethodGet, "/orders/foo", canned)
+
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			res := router.handle([]byte(`{"method":"GET","url":"/orders/foo"}`))
+			assert.Equal(t, []ErocError{}, res.Errors)
+		}()
+	}
+	<-done
+	<-done
+	assert.Nil(t, canned.Errors)
+}
+
+func TestMockRouterRecoversHandlerPanic(t *testing.T) {
+	router := &MockRouter{handlers: make(map[string]MockHandler)}
+	router.Handle(http.MethodGet, "/orders", func(req *ErocRequest) *ErocResponse {
+		panic("boom")
+	})
+
+	res := router.handle([]byte(`{"method":"GET","url":"/orders"}`))
+	assert.Equal(t, http.StatusInternalServerError, res.Status)
+	assert.Equal(t, "Handler panic", res.Errors[0].Message)
+	assert.Len(t, router.Errors(), 1)
+	assert.EqualError(t, router.Errors()[0], "GET /orders: handler panic: boom")
+}
@@ -0,0 +1,159 @@
+package backtest
+
+import "math"
+
+// FillTiming selects the bar price used to execute orders
+type FillTiming int
+
+const (
+	// FillNextBarOpen executes orders on the bars following the submission,
+	// market orders fill at the open price, limit and stop orders when the bar range reaches their price
+	FillNextBarOpen FillTiming = iota
+
+	// FillSameBarClose executes orders at the close price of the current bar,
+	// orders not filled on submission are checked against the close of the following bars
+	FillSameBarClose
+)
+
+// CommissionModel calculates commission charged for an execution
+type CommissionModel interface {
+	Commission(qty, price float64) float64
+}
+
+// SlippageModel adjusts execution price of market and stop orders
+type SlippageModel interface {
+	Price(side string, qty, price float64, bar *Bar) float64
+}
+
+// FillModel limits quantity which can be executed on a single bar
+type FillModel interface {
+	MaxQty(bar *Bar) float64
+}
+
+// NoCommission charges nothing
+type NoCommission struct{}
+
+func (NoCommission) Commission(qty, price float64) float64 {
+	return 0
+}
+
+// FixedCommission charges fixed amount per execution
+type FixedCommission struct {
+	Amount float64
+}
+
+func (c FixedCommission) Commission(qty, price float64) float64 {
+	return c.Amount
+}
+
+// PercentageCommission charges rate of the traded value, but not less than Minimum
+type PercentageCommission struct {
+	Rate    float64
+	Minimum float64
+}
+
+func (c PercentageCommission) Commission(qty, price float64) float64 {
+	return math.Max(qty*price*c.Rate, c.Minimum)
+}
+
+// PerShareCommission charges fixed amount per traded share, but not less than Minimum
+type PerShareCommission struct {
+	PerShare float64
+	Minimum  float64
+}
+
+func (c PerShareCommission) Commission(qty, price float64) float64 {
+	return math.Max(qty*c.PerShare, c.Minimum)
+}
+
+// NoSlippage executes orders exactly at the matched price
+type NoSlippage struct{}
+
+func (NoSlippage) Price(side string, qty, price float64, bar *Bar) float64 {
+	return price
+}
+
+// FixedSlippage moves execution price against the order by fixed amount
+type FixedSlippage struct {
+	Amount float64
+}
+
+func (s FixedSlippage) Price(side string, qty, price float64, bar *Bar) float64 {
+	return adverse(side, price, s.Amount)
+}
+
+// VolumeSlippage moves execution price against the order proportionally to the share of bar volume it takes;
+// the price moves by Impact of the price when the order takes the whole bar volume
+type VolumeSlippage struct {
+	Impact float64
+}
+
+func (s VolumeSlippage) Price(side string, qty, price float64, bar *Bar) float64 {
+	if bar.Volume <= 0 {
+		return price
+	}
+	return adverse(side, price, price*s.Impact*math.Min(qty/bar.Volume, 1))
+}
+
+// adverse returns price moved by amount against the order side
+func adverse(side string, price, amount float64) float64 {
+	if side == OrderSideSell {
+		return price - amount
+	}
+	return price + amount
+}
+
+// FullFill executes the whole order quantity regardless of bar volume
+type FullFill struct{}
+
+func (FullFill) MaxQty(bar *Bar) float64 {
+	return math.Inf(1)
+}
+
+// VolumeLimitFill executes up to Fraction of the bar volume, the rest of the order stays open
+// and is partially filled on the following bars
+type VolumeLimitFill struct {
+	Fraction float64
+}
+
+func (f VolumeLimitFill) MaxQty(bar *Bar) float64 {
+	return bar.Volume * f.Fraction
+}
+
+// EngineOption configures Engine
+type EngineOption func(*Engine)
+
+// WithCommission sets commission model, NoCommission is used by default
+func WithCommission(model CommissionModel) EngineOption {
+	return func(e *Engine) {
+		e.commission = model
+	}
+}
+
+// WithSlippage sets slippage model, NoSlippage is used by default
+func WithSlippage(model SlippageModel) EngineOption {
+	return func(e *Engine) {
+		e.slippage = model
+	}
+}
+
+// WithFillModel sets fill model, FullFill is used by default
+func WithFillModel(model FillModel) EngineOption {
+	return func(e *Engine) {
+		e.fillModel = model
+	}
+}
+
+// WithFillTiming sets fill timing, FillNextBarOpen is used by default
+func WithFillTiming(timing FillTiming) EngineOption {
+	return func(e *Engine) {
+		e.fillTiming = timing
+	}
+}
+
+// WithEngineCurrency sets account base currency, DefaultCurrency is used by default
+func WithEngineCurrency(currency string) EngineOption {
+	return func(e *Engine) {
+		e.currency = currency
+	}
+}
@@ -0,0 +1,64 @@
+package backtest
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestCommissionModels(t *testing.T) {
+	assert.Equal(t, 0.0, NoCommission{}.Commission(100, 10))
+	assert.Equal(t, 1.5, FixedCommission{Amount: 1.5}.Commission(100, 10))
+	assert.Equal(t, 1.0, PercentageCommission{Rate: 0.001}.Commission(100, 10))
+	assert.Equal(t, 2.0, PercentageCommission{Rate: 0.001, Minimum: 2}.Commission(100, 10))
+	assert.Equal(t, 0.5, PerShareCommission{PerShare: 0.005}.Commission(100, 10))
+	assert.Equal(t, 1.0, PerShareCommission{PerShare: 0.005, Minimum: 1}.Commission(100, 10))
+}
+
+func TestSlippageModels(t *testing.T) {
+	bar := &Bar{Volume: 1000}
+
+	assert.Equal(t, 10.0, NoSlippage{}.Price(OrderSideBuy, 100, 10, bar))
+	assert.Equal(t, 10.05, FixedSlippage{Amount: 0.05}.Price(OrderSideBuy, 100, 10, bar))
+	assert.Equal(t, 9.95, FixedSlippage{Amount: 0.05}.Price(OrderSideSell, 100, 10, bar))
+	assert.InDelta(t, 10.1, VolumeSlippage{Impact: 0.1}.Price(OrderSideBuy, 100, 10, bar), 1e-9)
+	assert.InDelta(t, 9.0, VolumeSlippage{Impact: 0.1}.Price(OrderSideSell, 5000, 10, bar), 1e-9)
+}
+
+func TestEnginePartialFillsLimitedByVolume(t *testing.T) {
+	engine := NewEngine(testBars(), DefaultCash, WithFillModel(VolumeLimitFill{Fraction: 0.1}))
+
+	engine.Handle(erocRequest(http.MethodPost, "/orders", "2021-01-04 21:00:00.000000", ErocRequestData{
+		"asset": "AAPL", "side": "buy", "type": "market", "qty": 250,
+	}))
+
+	res := engine.Handle(erocRequest(http.MethodGet, "/orders", "2021-01-05 21:00:00.000000", nil))
+	assert.Contains(t, res.Events, "order_partially_filled")
+	assert.Equal(t, OrderStatusPartiallyFilled, res.Data.([]Order)[0].Status)
+	assert.Equal(t, 100.0, res.Data.([]Order)[0].FilledQty)
+
+	res = engine.Handle(erocRequest(http.MethodGet, "/orders", "2021-01-07 21:00:00.000000", nil))
+	assert.Contains(t, res.Events, "order_filled")
+	assert.Equal(t, OrderStatusFilled, res.Data.([]Order)[0].Status)
+	assert.Equal(t, 3, len(engine.Fills()))
+}
+
+func TestEngineSameBarCloseWithCommissionAndSlippage(t *testing.T) {
+	engine := NewEngine(testBars(), DefaultCash,
+		WithFillTiming(FillSameBarClose),
+		WithCommission(FixedCommission{Amount: 1}),
+		WithSlippage(FixedSlippage{Amount: 0.5}),
+	)
+
+	engine.Handle(erocRequest(http.MethodGet, "/accounts", "2021-01-04 21:00:00.000000", nil))
+	res := engine.Handle(erocRequest(http.MethodPost, "/orders", "2021-01-04 21:00:00.000000", ErocRequestData{
+		"asset": "AAPL", "side": "buy", "type": "market", "qty": 10,
+	}))
+	assert.Contains(t, res.Events, "order_filled")
+
+	fills := engine.Fills()
+	assert.Equal(t, 1, len(fills))
+	assert.Equal(t, 104.5, fills[0].Price)
+	assert.Equal(t, 1.0, fills[0].Commission)
+	assert.Equal(t, DefaultCash-1045.0-1.0, engine.Cash())
+}
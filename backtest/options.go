@@ -0,0 +1,185 @@
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const DefaultSocketURL = "tcp://0.0.0.0:3003"
+
+var currencyRegexp = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// Options are backtest session settings
+type Options struct {
+	// SocketURL of the EROC router, used unless Transport or DataSource is set
+	SocketURL string
+
+	// Capital is an initial account cash
+	Capital float64
+
+	// Currency is an account base currency
+	Currency string
+
+	// Resolution is a default bar resolution, used when current bar info doesn't have one
+	Resolution string
+
+	// Location is a timezone of the session datetimes
+	Location *time.Location
+
+	// DataSource provides bars to the local Engine which is used instead of the EROC router
+	DataSource DataSource
+
+	// EngineOptions configure the local Engine created for DataSource
+	EngineOptions []EngineOption
+
+	// Warmup is a look-back period of DataSource bars loaded before start, so the strategy
+	// can request history on the first bars; these bars aren't delivered to the strategy
+	Warmup time.Duration
+
+	// Transport delivers EROC requests instead of ZMQ connection to the EROC router
+	Transport Transport
+}
+
+// Option configures backtest session
+type Option func(*Options)
+
+// WithSocketURL sets EROC router socket URL, DefaultSocketURL is used by default
+func WithSocketURL(socketUrl string) Option {
+	return func(o *Options) {
+		o.SocketURL = socketUrl
+	}
+}
+
+// WithCapital sets initial account cash, DefaultCash is used by default
+func WithCapital(capital float64) Option {
+	return func(o *Options) {
+		o.Capital = capital
+	}
+}
+
+// WithCurrency sets account base currency, DefaultCurrency is used by default
+func WithCurrency(currency string) Option {
+	return func(o *Options) {
+		o.Currency = currency
+	}
+}
+
+// WithResolution sets default bar resolution
+func WithResolution(resolution string) Option {
+	return func(o *Options) {
+		o.Resolution = resolution
+	}
+}
+
+// WithLocation sets timezone of the session datetimes, UTC is used by default
+func WithLocation(location *time.Location) Option {
+	return func(o *Options) {
+		o.Location = location
+	}
+}
+
+// WithDataSource runs the session on the local Engine fed by selected data source
+func WithDataSource(source DataSource, opts ...EngineOption) Option {
+	return func(o *Options) {
+		o.DataSource = source
+		o.EngineOptions = opts
+	}
+}
+
+// WithWarmup loads DataSource bars of selected period before start, so they can be requested by the strategy
+func WithWarmup(warmup time.Duration) Option {
+	return func(o *Options) {
+		o.Warmup = warmup
+	}
+}
+
+// WithTransport sends EROC requests using selected transport
+func WithTransport(transport Transport) Option {
+	return func(o *Options) {
+		o.Transport = transport
+	}
+}
+
+// validate returns an error if session can't be created with the options
+func (o *Options) validate(start, end time.Time) error {
+	if start.IsZero() || end.IsZero() {
+		return errors.New("start and end are required")
+	}
+	if !start.Before(end) {
+		return errors.New("start must be before end")
+	}
+	if o.Capital <= 0 {
+		return errors.New("capital must be positive")
+	}
+	if !currencyRegexp.MatchString(o.Currency) {
+		return fmt.Errorf("invalid currency %q", o.Currency)
+	}
+	if o.Resolution != "" {
+		if _, err := ParseResolution(o.Resolution); err != nil {
+			return err
+		}
+	}
+	if o.Location == nil {
+		return errors.New("location is required")
+	}
+	if o.Warmup < 0 {
+		return errors.New("warmup can't be negative")
+	}
+	if o.Transport != nil && o.DataSource != nil {
+		return errors.New("transport and data source can't be used together")
+	}
+	if o.Transport == nil && o.DataSource == nil {
+		if !strings.HasPrefix(o.SocketURL, "tcp://") && !strings.HasPrefix(o.SocketURL, "ipc://") &&
+			!strings.HasPrefix(o.SocketURL, "inproc://") {
+			return fmt.Errorf("invalid socket URL %q", o.SocketURL)
+		}
+	}
+	return nil
+}
+
+// NewBacktestWithOptions create new Backtest object with selected start, end dates and session options.
+// Options are validated before connecting, EROC router at DefaultSocketURL is used unless
+// transport, data source or socket URL is set
+func NewBacktestWithOptions(start, end time.Time, opts ...Option) (*Backtest, error) {
+	o := &Options{
+		SocketURL: DefaultSocketURL,
+		Capital:   DefaultCash,
+		Currency:  DefaultCurrency,
+		Location:  time.UTC,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if err := o.validate(start, end); err != nil {
+		return nil, err
+	}
+
+	start, end = start.In(o.Location), end.In(o.Location)
+
+	transport := o.Transport
+	switch {
+	case transport != nil:
+	case o.DataSource != nil:
+		bars, err := o.DataSource.Bars(start.Add(-o.Warmup), end)
+		if err != nil {
+			return nil, err
+		}
+		engineOpts := append([]EngineOption{WithEngineCurrency(o.Currency)}, o.EngineOptions...)
+		transport = NewEngine(bars, o.Capital, engineOpts...)
+	default:
+		zmqConn, err := NewZmq(o.SocketURL)
+		if err != nil {
+			return nil, err
+		}
+		transport = zmqConn
+	}
+
+	b := NewBacktestWithTransport(start.Format(DatetimeLayout), end.Format(DatetimeLayout), transport)
+	b.options = o
+
+	return b, nil
+}
@@ -0,0 +1,96 @@
+package backtest
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewBacktestWithOptionsValidation(t *testing.T) {
+	start := time.Date(2021, 1, 4, 21, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 1, 7, 21, 0, 0, 0, time.UTC)
+	source := BarsSource(testBars())
+
+	for name, opts := range map[string][]Option{
+		"capital":    {WithDataSource(source), WithCapital(0)},
+		"currency":   {WithDataSource(source), WithCurrency("usd")},
+		"resolution": {WithDataSource(source), WithResolution("1y")},
+		"location":   {WithDataSource(source), WithLocation(nil)},
+		"socket":     {WithSocketURL("0.0.0.0:3003")},
+		"exclusive":  {WithDataSource(source), WithTransport(NewEngine(testBars(), DefaultCash))},
+	} {
+		_, err := NewBacktestWithOptions(start, end, opts...)
+		assert.Error(t, err, name)
+	}
+
+	_, err := NewBacktestWithOptions(end, start, WithDataSource(source))
+	assert.Error(t, err)
+
+	_, err = NewBacktestWithOptions(time.Time{}, end, WithDataSource(source))
+	assert.Error(t, err)
+}
+
+func TestNewBacktestWithDataSource(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+
+	start := time.Date(2021, 1, 4, 16, 0, 0, 0, location)
+	end := time.Date(2021, 1, 7, 16, 0, 0, 0, location)
+
+	bt, err := NewBacktestWithOptions(start, end,
+		WithDataSource(BarsSource(testBars())),
+		WithCapital(5000),
+		WithCurrency("EUR"),
+		WithResolution("1day"),
+		WithLocation(location),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "2021-01-04 16:00:00.000000", bt.start)
+	assert.Equal(t, location, bt.Location())
+
+	var bars []string
+	runner, err := NewRunner(bt, func(tradehook string, payload []byte) {
+		bars = append(bars, tradehook)
+	}, []string{"AAPL"}, "")
+	assert.NoError(t, err)
+	assert.NoError(t, runner.Run())
+	assert.Equal(t, 4, len(bars))
+
+	req, err := http.NewRequest(http.MethodGet, "/accounts", nil)
+	assert.NoError(t, err)
+
+	res := bt.CallErocMethod(req)
+	assert.Equal(t, 200, res.StatusCode)
+
+	engine := bt.transport.(*Engine)
+	assert.Equal(t, 5000.0, engine.Cash())
+	assert.Equal(t, "EUR", engine.currency)
+}
+
+func TestNewBacktestWithWarmup(t *testing.T) {
+	start := time.Date(2021, 1, 6, 21, 0, 0, 0, time.UTC)
+	end := time.Date(2021, 1, 7, 21, 0, 0, 0, time.UTC)
+
+	history := func(opts ...Option) int {
+		bt, err := NewBacktestWithOptions(start, end, append(opts, WithDataSource(BarsSource(testBars())))...)
+		assert.NoError(t, err)
+		bt.SetCurrentBarInfo(&BarInfo{Datetime: "2021-01-06 21:00:00.000000", Resolution: "1day"})
+
+		req, err := http.NewRequest(http.MethodGet, "/bars?assets=AAPL&start=2021-01-01", nil)
+		assert.NoError(t, err)
+
+		var body struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		assert.NoError(t, json.NewDecoder(bt.CallErocMethod(req).Body).Decode(&body))
+		return len(body.Data)
+	}
+
+	assert.Equal(t, 1, history())
+	assert.Equal(t, 3, history(WithWarmup(48*time.Hour)))
+
+	_, err := NewBacktestWithOptions(start, end, WithDataSource(BarsSource(testBars())), WithWarmup(-time.Hour))
+	assert.Error(t, err)
+}
@@ -0,0 +1,232 @@
+// Package parquet reads bars of Parquet files for offline backtests. It's a separate package,
+// so the Parquet library is only built into strategies which read Parquet files
+package parquet
+
+import (
+	"errors"
+	"fmt"
+	"github.com/tradologics/go-sdk/backtest"
+	"github.com/xitongsys/parquet-go/common"
+	pq "github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/reader"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/types"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// batchSize is a number of rows read from each column at once
+const batchSize = 4096
+
+// NewSource create new backtest.FileSource of Parquet files matching selected path or glob pattern,
+// e.g. "data/*.parquet" for a file per asset
+func NewSource(path string, opts ...backtest.FileOption) (*backtest.FileSource, error) {
+	return backtest.NewDecodedSource(path, Decode, opts...)
+}
+
+// Decode opens rows of a Parquet file as a backtest.RecordReader, it's a backtest.RecordDecoder.
+// Columns must be flat: dates are read as "YYYY-MM-DD", timestamps adjusted to UTC as RFC 3339
+// and local ones without timezone, so they are read in the location set by backtest.WithFileLocation
+func Decode(file io.ReaderAt, size int64) (backtest.RecordReader, error) {
+	pr, err := reader.NewParquetColumnReader(newFile(file, size), 1)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &recordReader{parquet: pr, rows: pr.GetNumRows()}
+	for _, path := range pr.SchemaHandler.ValueColumns {
+		index := pr.SchemaHandler.MapIndex[path]
+		element := pr.SchemaHandler.SchemaElements[index]
+		name := pr.SchemaHandler.GetExName(int(index))
+
+		if strings.Count(path, common.PAR_GO_PATH_DELIMITER) != 1 || element.GetRepetitionType() == pq.FieldRepetitionType_REPEATED {
+			return nil, fmt.Errorf("column %q isn't flat", name)
+		}
+		r.header = append(r.header, name)
+		r.columns = append(r.columns, element)
+	}
+	if len(r.columns) == 0 {
+		return nil, errors.New("file has no columns")
+	}
+	return r, nil
+}
+
+// recordReader reads Parquet rows in batches and formats their values as strings
+type recordReader struct {
+	parquet *reader.ParquetReader
+	header  []string
+	columns []*pq.SchemaElement
+	rows    int64
+
+	headerRead bool
+	read       int64
+	batch      [][]interface{}
+	next       int
+	record     []string
+}
+
+// Read returns the header first and then rows of the file
+func (r *recordReader) Read() ([]string, error) {
+	if !r.headerRead {
+		r.headerRead = true
+		return r.header, nil
+	}
+
+	if r.batch == nil || r.next >= len(r.batch[0]) {
+		if err := r.readBatch(); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.record == nil {
+		r.record = make([]string, len(r.columns))
+	}
+	for i, column := range r.columns {
+		value, err := format(r.batch[i][r.next], column)
+		if err != nil {
+			return nil, fmt.Errorf("column %q: %v", r.header[i], err)
+		}
+		r.record[i] = value
+	}
+	r.next++
+	return r.record, nil
+}
+
+// readBatch reads next rows of every column
+func (r *recordReader) readBatch() error {
+	if r.read >= r.rows {
+		return io.EOF
+	}
+
+	num := r.rows - r.read
+	if num > batchSize {
+		num = batchSize
+	}
+
+	r.batch = make([][]interface{}, len(r.columns))
+	for i := range r.columns {
+		values, _, _, err := r.parquet.ReadColumnByIndex(int64(i), num)
+		if err != nil {
+			return err
+		}
+		if int64(len(values)) != num {
+			return fmt.Errorf("column %q: expected %d values, got %d", r.header[i], num, len(values))
+		}
+		r.batch[i] = values
+	}
+
+	r.read += num
+	r.next = 0
+	return nil
+}
+
+// format returns value of the column as a string, null is an empty string
+func format(value interface{}, column *pq.SchemaElement) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case int32:
+		if isDate(column) {
+			return time.Unix(int64(v)*86400, 0).UTC().Format("2006-01-02"), nil
+		}
+		return formatInt(int64(v), column), nil
+	case int64:
+		if dt, utc, ok := timestamp(v, column); ok {
+			if utc {
+				return dt.Format(time.RFC3339Nano), nil
+			}
+			return dt.Format(backtest.DatetimeLayout), nil
+		}
+		return formatInt(v, column), nil
+	case string:
+		if column.GetType() == pq.Type_INT96 {
+			return types.INT96ToTime(v).Format(time.RFC3339Nano), nil
+		}
+		if isDecimal(column) {
+			return types.DECIMAL_BYTE_ARRAY_ToString([]byte(v), int(column.GetPrecision()), int(column.GetScale())), nil
+		}
+		return v, nil
+	}
+	return "", fmt.Errorf("unsupported value %T", value)
+}
+
+// formatInt returns integer or decimal with the scale of the column
+func formatInt(value int64, column *pq.SchemaElement) string {
+	if !isDecimal(column) {
+		return strconv.FormatInt(value, 10)
+	}
+	return strconv.FormatFloat(float64(value)/math.Pow10(int(column.GetScale())), 'f', -1, 64)
+}
+
+// timestamp converts int64 timestamp column value to time in UTC and reports whether it's adjusted to UTC
+func timestamp(value int64, column *pq.SchemaElement) (time.Time, bool, bool) {
+	if logical := column.GetLogicalType(); logical != nil && logical.IsSetTIMESTAMP() {
+		unit := logical.GetTIMESTAMP().GetUnit()
+		switch {
+		case unit.IsSetMILLIS():
+			return time.UnixMilli(value).UTC(), logical.GetTIMESTAMP().GetIsAdjustedToUTC(), true
+		case unit.IsSetMICROS():
+			return time.UnixMicro(value).UTC(), logical.GetTIMESTAMP().GetIsAdjustedToUTC(), true
+		case unit.IsSetNANOS():
+			return time.Unix(0, value).UTC(), logical.GetTIMESTAMP().GetIsAdjustedToUTC(), true
+		}
+	}
+
+	switch column.GetConvertedType() {
+	case pq.ConvertedType_TIMESTAMP_MILLIS:
+		return time.UnixMilli(value).UTC(), true, true
+	case pq.ConvertedType_TIMESTAMP_MICROS:
+		return time.UnixMicro(value).UTC(), true, true
+	}
+	return time.Time{}, false, false
+}
+
+// isDate returns true if int32 column holds days since the epoch
+func isDate(column *pq.SchemaElement) bool {
+	logical := column.GetLogicalType()
+	return column.GetConvertedType() == pq.ConvertedType_DATE || (logical != nil && logical.IsSetDATE())
+}
+
+// isDecimal returns true if column holds unscaled decimals
+func isDecimal(column *pq.SchemaElement) bool {
+	logical := column.GetLogicalType()
+	return column.GetConvertedType() == pq.ConvertedType_DECIMAL || (logical != nil && logical.IsSetDECIMAL())
+}
+
+// readerFile is a read-only source.ParquetFile of io.ReaderAt, the reader opens a file per column
+type readerFile struct {
+	*io.SectionReader
+	readerAt io.ReaderAt
+	size     int64
+}
+
+// newFile create new readerFile reading from the start of readerAt
+func newFile(readerAt io.ReaderAt, size int64) *readerFile {
+	return &readerFile{SectionReader: io.NewSectionReader(readerAt, 0, size), readerAt: readerAt, size: size}
+}
+
+func (f *readerFile) Open(name string) (source.ParquetFile, error) {
+	return newFile(f.readerAt, f.size), nil
+}
+
+func (f *readerFile) Create(name string) (source.ParquetFile, error) {
+	return nil, errors.New("parquet file is read-only")
+}
+
+func (f *readerFile) Write(p []byte) (int, error) {
+	return 0, errors.New("parquet file is read-only")
+}
+
+// Close doesn't close readerAt, which is closed by its owner
+func (f *readerFile) Close() error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package parquet
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tradologics/go-sdk/backtest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSourceReadsAssetColumnAndTimestamps(t *testing.T) {
+	source, err := NewSource(filepath.Join("testdata", "bars.parquet"))
+	assert.NoError(t, err)
+
+	bars, err := source.Bars(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 31, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, []backtest.Bar{
+		{Datetime: time.Date(2021, 1, 4, 21, 0, 0, 0, time.UTC), Open: 100, High: 105, Low: 99, Close: 104, Volume: 1200},
+		{Datetime: time.Date(2021, 1, 5, 21, 0, 0, 0, time.UTC), Open: 104, High: 108, Low: 103, Close: 107, Volume: 1000},
+	}, utcBars(bars["AAPL"]))
+
+	// Null volume is zero
+	assert.Equal(t, []backtest.Bar{
+		{Datetime: time.Date(2021, 1, 4, 21, 0, 0, 0, time.UTC), Open: 200, High: 205, Low: 199, Close: 204},
+	}, utcBars(bars["MSFT"]))
+}
+
+func TestSourceReadsDatesOfFilePerAsset(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+
+	source, err := NewSource(filepath.Join("testdata", "SPY.parquet"),
+		backtest.WithColumns(backtest.ColumnMapping{Datetime: "date", Close: "close"}),
+		backtest.WithFileLocation(location),
+	)
+	assert.NoError(t, err)
+
+	bars, err := source.Bars(time.Date(2021, 1, 5, 0, 0, 0, 0, location), time.Date(2021, 1, 31, 0, 0, 0, 0, location))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(bars["SPY"]))
+	assert.Equal(t, time.Date(2021, 1, 5, 0, 0, 0, 0, location), bars["SPY"][0].Datetime)
+	assert.Equal(t, 371.25, bars["SPY"][0].Close)
+	assert.Equal(t, 371.25, bars["SPY"][0].Open)
+}
+
+func TestDecodeReadsHeaderAndRows(t *testing.T) {
+	file, err := os.Open(filepath.Join("testdata", "bars.parquet"))
+	assert.NoError(t, err)
+	defer file.Close()
+
+	info, err := file.Stat()
+	assert.NoError(t, err)
+
+	reader, err := Decode(file, info.Size())
+	assert.NoError(t, err)
+
+	header, err := reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"asset", "datetime", "Open", "High", "Low", "Close", "Volume"}, header)
+
+	record, err := reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"AAPL", "2021-01-05T21:00:00Z", "104", "108", "103", "107", "1000"}, record)
+
+	_, err = Decode(file, 10)
+	assert.Error(t, err)
+}
+
+// utcBars returns bars with datetimes in UTC
+func utcBars(bars []backtest.Bar) []backtest.Bar {
+	for i := range bars {
+		bars[i].Datetime = bars[i].Datetime.UTC()
+	}
+	return bars
+}
@@ -0,0 +1,206 @@
+package backtest
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Record is a single EROC request and response pair of a recorded session
+type Record struct {
+	Seq      int             `json:"seq"`
+	Bar      BarInfo         `json:"bar"`
+	Request  ErocRequest     `json:"request"`
+	Response json.RawMessage `json:"response"`
+}
+
+// Recorder is a Transport which writes every request and response of the wrapped transport
+// as JSON lines, so the session can be replayed later
+type Recorder struct {
+	mu        sync.Mutex
+	transport Transport
+	writer    io.Writer
+	closer    io.Closer
+	seq       int
+	request   *ErocRequest
+}
+
+// NewRecorder create new Recorder of the transport which writes records to selected writer
+func NewRecorder(transport Transport, writer io.Writer) *Recorder {
+	r := &Recorder{transport: transport, writer: writer}
+	if closer, ok := writer.(io.Closer); ok {
+		r.closer = closer
+	}
+	return r
+}
+
+// SendJSON sends request using wrapped transport and keeps it until the response is received
+func (r *Recorder) SendJSON(src interface{}) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+
+	var req ErocRequest
+	if err = json.Unmarshal(data, &req); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.request = &req
+	r.mu.Unlock()
+
+	return r.transport.SendJSON(src)
+}
+
+// ReceiveJSON receives response using wrapped transport and writes it along with the request
+func (r *Recorder) ReceiveJSON(dst interface{}) error {
+	var response json.RawMessage
+	if err := r.transport.ReceiveJSON(&response); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.request != nil {
+		r.seq++
+		line, err := json.Marshal(Record{
+			Seq:      r.seq,
+			Bar:      BarInfo{Datetime: r.request.Headers.Datetime, Resolution: r.request.Headers.Resolution},
+			Request:  *r.request,
+			Response: response,
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, err = r.writer.Write(append(line, '\n')); err != nil {
+			return err
+		}
+		r.request = nil
+	}
+
+	return json.Unmarshal(response, dst)
+}
+
+// Close wrapped transport and the writer if it's closable
+func (r *Recorder) Close() {
+	r.transport.Close()
+	if r.closer != nil {
+		r.closer.Close()
+	}
+}
+
+// Record starts writing every EROC request and response of the Backtest to the file,
+// the file is closed with the Backtest
+func (b *Backtest) Record(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	b.transport = NewRecorder(b.transport, file)
+	return nil
+}
+
+// Replay is a Transport which answers requests with the responses of a recorded session
+// without the EROC router. A request is matched to the first unused record with the same method,
+// URL and bar datetime, so a single bar can be replayed as well as the whole session
+type Replay struct {
+	mu       sync.Mutex
+	records  []Record
+	used     []bool
+	response json.RawMessage
+}
+
+// NewReplay create new Replay of the session recorded to the file
+func NewReplay(path string) (*Replay, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadReplay(file)
+}
+
+// ReadReplay create new Replay of the session records read from the reader
+func ReadReplay(reader io.Reader) (*Replay, error) {
+	var records []Record
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &Replay{records: records, used: make([]bool, len(records))}, nil
+}
+
+// Records returns all records of the session
+func (r *Replay) Records() []Record {
+	return r.records
+}
+
+// SendJSON finds recorded response of the request
+func (r *Replay) SendJSON(src interface{}) error {
+	data, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+
+	var req ErocRequest
+	if err = json.Unmarshal(data, &req); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, record := range r.records {
+		if r.used[i] || record.Request.Method != req.Method || record.Request.Url != req.Url ||
+			record.Request.Headers.Datetime != req.Headers.Datetime {
+			continue
+		}
+
+		r.used[i] = true
+		r.response = record.Response
+		return nil
+	}
+
+	return fmt.Errorf("no recorded response for %s %s at %q", req.Method, req.Url, req.Headers.Datetime)
+}
+
+// ReceiveJSON parse recorded response of the last sent request into selected struct
+func (r *Replay) ReceiveJSON(dst interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.response == nil {
+		return errors.New("no request was sent")
+	}
+
+	data := r.response
+	r.response = nil
+
+	return json.Unmarshal(data, dst)
+}
+
+// Close does nothing, Replay has no connection to release
+func (r *Replay) Close() {}
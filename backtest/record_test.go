@@ -0,0 +1,60 @@
+package backtest
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func recordingStrategy(t *testing.T, bt *Backtest, tradehooks *[]string) func(string, []byte) {
+	return func(tradehook string, payload []byte) {
+		*tradehooks = append(*tradehooks, tradehook)
+		if tradehook == "bar" && len(*tradehooks) == 1 {
+			postOrder(t, bt, map[string]interface{}{"asset": "AAPL", "side": "buy", "type": "market", "qty": 1})
+		}
+	}
+}
+
+func TestRecordAndReplaySession(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.jsonl")
+	start, end := "2021-01-04 21:00:00.000000", "2021-01-07 21:00:00.000000"
+
+	// Record session using local engine
+	bt := NewBacktestWithTransport(start, end, NewEngine(testBars(), DefaultCash))
+	assert.NoError(t, bt.Record(path))
+
+	var recorded []string
+	runner, err := NewRunner(bt, recordingStrategy(t, bt, &recorded), []string{"AAPL"}, "1day")
+	assert.NoError(t, err)
+	assert.NoError(t, runner.Run())
+	bt.Close()
+
+	replay, err := NewReplay(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(replay.Records()))
+	assert.Equal(t, "2021-01-04 21:00:00.000000", replay.Records()[0].Bar.Datetime)
+	assert.Equal(t, http.MethodPost, replay.Records()[1].Request.Method)
+
+	// Replay the whole session without the engine
+	bt = NewBacktestWithTransport(start, end, replay)
+
+	var replayed []string
+	runner, err = NewRunner(bt, recordingStrategy(t, bt, &replayed), []string{"AAPL"}, "1day")
+	assert.NoError(t, err)
+	assert.NoError(t, runner.Run())
+	assert.Equal(t, recorded, replayed)
+
+	// Replay a single bar
+	replay, err = NewReplay(path)
+	assert.NoError(t, err)
+	bt = NewBacktestWithTransport(start, end, replay)
+	bt.SetCurrentBarInfo(&BarInfo{Datetime: "2021-01-06 21:00:00.000000", Resolution: "1day"})
+
+	req, err := http.NewRequest(http.MethodGet, "/bars?assets=AAPL&end=2021-01-06+21%3A00%3A00.000000&resolution=1day&start=2021-01-05+21%3A00%3A00.000001", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 200, bt.CallErocMethod(req).StatusCode)
+
+	// Not recorded request fails
+	assert.Equal(t, 502, bt.CallErocMethod(req).StatusCode)
+}
@@ -0,0 +1,117 @@
+package backtest
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+)
+
+const (
+	chartWidth  = 800
+	chartHeight = 240
+)
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"pct": func(v float64) string {
+		return fmt.Sprintf("%.2f%%", v*100)
+	},
+	"num": func(v float64) string {
+		return fmt.Sprintf("%.2f", v)
+	},
+	"date": func(r *Report) string {
+		return fmt.Sprintf("%s - %s", r.Start.Format("2006-01-02"), r.End.Format("2006-01-02"))
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Backtest report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { padding: 4px 12px; border-bottom: 1px solid #ddd; text-align: right; }
+td:first-child, th:first-child { text-align: left; }
+svg { border: 1px solid #ddd; margin-bottom: 2em; }
+</style>
+</head>
+<body>
+<h1>Backtest report</h1>
+<p>{{date .Report}}</p>
+<svg width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+<polyline fill="none" stroke="#1f77b4" stroke-width="1.5" points="{{.Points}}"/>
+</svg>
+<table>
+<tr><td>Start equity</td><td>{{num .Report.StartEquity}}</td></tr>
+<tr><td>End equity</td><td>{{num .Report.EndEquity}}</td></tr>
+<tr><td>Total return</td><td>{{pct .Report.TotalReturn}}</td></tr>
+<tr><td>Annualized return</td><td>{{pct .Report.AnnualizedReturn}}</td></tr>
+<tr><td>Volatility</td><td>{{pct .Report.Volatility}}</td></tr>
+<tr><td>Sharpe ratio</td><td>{{num .Report.Sharpe}}</td></tr>
+<tr><td>Sortino ratio</td><td>{{num .Report.Sortino}}</td></tr>
+<tr><td>Max drawdown</td><td>{{pct .Report.MaxDrawdown}}</td></tr>
+<tr><td>Max drawdown duration, days</td><td>{{num .Report.MaxDrawdownDays}}</td></tr>
+<tr><td>Trades</td><td>{{len .Report.Trades}}</td></tr>
+<tr><td>Win rate</td><td>{{pct .Report.WinRate}}</td></tr>
+<tr><td>Profit factor</td><td>{{num .Report.ProfitFactor}}</td></tr>
+<tr><td>Exposure</td><td>{{pct .Report.Exposure}}</td></tr>
+<tr><td>Turnover</td><td>{{num .Report.Turnover}}</td></tr>
+</table>
+{{if .Report.Trades}}
+<h2>Trades</h2>
+<table>
+<tr><th>Asset</th><th>Side</th><th>Qty</th><th>Entry</th><th>Entry price</th><th>Exit</th><th>Exit price</th><th>P&amp;L</th></tr>
+{{range .Report.Trades}}
+<tr><td>{{.Asset}}</td><td>{{.Side}}</td><td>{{num .Qty}}</td><td>{{.EntryDatetime.Format "2006-01-02 15:04"}}</td><td>{{num .EntryPrice}}</td><td>{{.ExitDatetime.Format "2006-01-02 15:04"}}</td><td>{{num .ExitPrice}}</td><td>{{num .PL}}</td></tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
+
+// WriteHTML writes self-contained HTML report with equity chart, metrics and trades
+func (r *Report) WriteHTML(w io.Writer) error {
+	return reportTemplate.Execute(w, struct {
+		Report *Report
+		Width  int
+		Height int
+		Points string
+	}{
+		Report: r,
+		Width:  chartWidth,
+		Height: chartHeight,
+		Points: r.chartPoints(),
+	})
+}
+
+// chartPoints returns equity curve scaled to the chart size as SVG polyline points
+func (r *Report) chartPoints() string {
+	if len(r.Equity) == 0 {
+		return ""
+	}
+
+	low, high := r.Equity[0].Equity, r.Equity[0].Equity
+	for _, p := range r.Equity {
+		if p.Equity < low {
+			low = p.Equity
+		}
+		if p.Equity > high {
+			high = p.Equity
+		}
+	}
+	if high == low {
+		high = low + 1
+	}
+
+	points := make([]string, len(r.Equity))
+	for i, p := range r.Equity {
+		x := 0.0
+		if len(r.Equity) > 1 {
+			x = float64(i) / float64(len(r.Equity)-1) * chartWidth
+		}
+		y := chartHeight - (p.Equity-low)/(high-low)*chartHeight
+		points[i] = fmt.Sprintf("%.1f,%.1f", x, y)
+	}
+	return strings.Join(points, " ")
+}
@@ -0,0 +1,308 @@
+package backtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var resolutionRegexp = regexp.MustCompile(`^(\d+)\s*([a-z]+)$`)
+
+// ParseResolution converts resolution like "1min", "5m", "1h", "1day" or "1w" to bar duration
+func ParseResolution(resolution string) (time.Duration, error) {
+	match := resolutionRegexp.FindStringSubmatch(strings.ToLower(strings.TrimSpace(resolution)))
+	if match == nil {
+		return 0, fmt.Errorf("invalid resolution %q", resolution)
+	}
+
+	n, err := strconv.Atoi(match[1])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid resolution %q", resolution)
+	}
+
+	var unit time.Duration
+	switch match[2] {
+	case "s", "sec", "second", "seconds":
+		unit = time.Second
+	case "m", "min", "minute", "minutes":
+		unit = time.Minute
+	case "h", "hour", "hours":
+		unit = time.Hour
+	case "d", "day", "days":
+		unit = 24 * time.Hour
+	case "w", "week", "weeks":
+		unit = 7 * 24 * time.Hour
+	default:
+		return 0, fmt.Errorf("invalid resolution %q", resolution)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// MissingBars selects how a Feed handles assets without a bar at the aligned datetime
+type MissingBars int
+
+const (
+	// MissingSkip delivers the bar without assets which have no bar
+	MissingSkip MissingBars = iota
+
+	// MissingForwardFill repeats the last known bar of the asset with open, high and low
+	// set to its close and zero volume; assets without any bar yet are skipped
+	MissingForwardFill
+
+	// MissingDrop doesn't deliver the bar unless every asset has one
+	MissingDrop
+)
+
+// Feed is a universe of assets delivered to the strategy at a single resolution
+type Feed struct {
+	Assets     []string
+	Resolution string
+	Missing    MissingBars
+}
+
+// feedState is a Feed being run along with its step and last delivered bars
+type feedState struct {
+	Feed
+	step time.Duration
+	last map[string]interface{}
+}
+
+// Runner drives a backtest: it steps bars between Backtest start and end dates, sets current bar info
+// and invokes strategy with "bar" tradehooks and runtime events emitted by the EROC router.
+// Bars of several feeds are aligned on a common clock, feeds due at the same datetime are delivered
+// from the finest resolution to the coarsest
+type Runner struct {
+	backtest *Backtest
+	strategy func(tradehook string, payload []byte)
+	feeds    []*feedState
+	step     time.Duration
+}
+
+// NewRunner create new Runner for the Backtest which delivers bars of selected assets and resolution
+// to the strategy, which is the same handler as used by server.Start;
+// empty resolution falls back to the one set by WithResolution
+func NewRunner(bt *Backtest, strategy func(tradehook string, payload []byte), assets []string, resolution string) (*Runner, error) {
+	return NewMultiRunner(bt, strategy, Feed{Assets: assets, Resolution: resolution})
+}
+
+// NewMultiRunner create new Runner for the Backtest which delivers bars of every feed to the strategy;
+// each "bar" tradehook carries bars of a single feed keyed by the aligned datetime and its resolution
+func NewMultiRunner(bt *Backtest, strategy func(tradehook string, payload []byte), feeds ...Feed) (*Runner, error) {
+	if bt == nil {
+		return nil, errors.New("backtest is required")
+	}
+	if strategy == nil {
+		return nil, errors.New("strategy is required")
+	}
+	if len(feeds) == 0 {
+		return nil, errors.New("at least one feed is required")
+	}
+
+	r := &Runner{backtest: bt, strategy: strategy}
+	for _, feed := range feeds {
+		if feed.Resolution == "" && bt.options != nil {
+			feed.Resolution = bt.options.Resolution
+		}
+
+		step, err := ParseResolution(feed.Resolution)
+		if err != nil {
+			return nil, err
+		}
+
+		r.feeds = append(r.feeds, &feedState{Feed: feed, step: step, last: make(map[string]interface{})})
+		r.step = gcd(r.step, step)
+	}
+
+	sort.SliceStable(r.feeds, func(i, j int) bool {
+		return r.feeds[i].step < r.feeds[j].step
+	})
+
+	return r, nil
+}
+
+// Run steps all bars from start to end and returns the first EROC error
+func (r *Runner) Run() error {
+	start, err := ParseDatetimeInLocation(r.backtest.start, r.backtest.Location())
+	if err != nil {
+		return err
+	}
+	end, err := ParseDatetimeInLocation(r.backtest.end, r.backtest.Location())
+	if err != nil {
+		return err
+	}
+
+	for dt := start; !dt.After(end); dt = dt.Add(r.step) {
+		for _, feed := range r.feeds {
+			if dt.Sub(start)%feed.step != 0 {
+				continue
+			}
+			if err = r.processBar(feed, dt); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// processBar processes a single bar of the feed: delivers events emitted until the bar, then the bar itself
+// and then events caused by the strategy reaction
+func (r *Runner) processBar(feed *feedState, dt time.Time) error {
+	r.backtest.SetCurrentBarInfo(&BarInfo{
+		Datetime:   dt.Format(DatetimeLayout),
+		Resolution: feed.Resolution,
+	})
+
+	bars, err := r.fetchBars(feed, dt)
+	if err != nil {
+		return err
+	}
+
+	r.dispatchEvents()
+
+	aligned := feed.align(bars)
+	if len(aligned) > 0 {
+		payload, err := json.Marshal(map[string]interface{}{
+			"assets":     feed.Assets,
+			"resolution": feed.Resolution,
+			"bars":       map[string]interface{}{dt.Format(BarsKeyLayout): aligned},
+		})
+		if err != nil {
+			return err
+		}
+
+		r.strategy("bar", payload)
+		r.dispatchEvents()
+	}
+	return nil
+}
+
+// fetchBars requests bars of the feed which closed within the step ending at the current datetime
+func (r *Runner) fetchBars(feed *feedState, dt time.Time) (map[string]interface{}, error) {
+	query := url.Values{}
+	query.Set("assets", strings.Join(feed.Assets, ","))
+	query.Set("resolution", feed.Resolution)
+	query.Set("start", dt.Add(-feed.step+time.Microsecond).Format(DatetimeLayout))
+	query.Set("end", dt.Format(DatetimeLayout))
+
+	req, err := http.NewRequest(http.MethodGet, "/bars?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res := r.backtest.CallErocMethod(req)
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var data struct {
+		Errors []ErocError            `json:"errors"`
+		Data   map[string]interface{} `json:"data"`
+	}
+	if err = json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		if len(data.Errors) > 0 {
+			return nil, fmt.Errorf("bars request failed: %s", data.Errors[0].Message)
+		}
+		return nil, fmt.Errorf("bars request failed: %s", res.Status)
+	}
+	return data.Data, nil
+}
+
+// align picks the latest bar of every feed asset and handles missing ones;
+// returns nil if no asset has a new bar or the bar is dropped
+func (f *feedState) align(bars map[string]interface{}) map[string]interface{} {
+	keys := make([]string, 0, len(bars))
+	for key := range bars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	latest := make(map[string]interface{})
+	for _, key := range keys {
+		assetBars, ok := bars[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for asset, bar := range assetBars {
+			latest[asset] = bar
+		}
+	}
+	if len(latest) == 0 {
+		return nil
+	}
+
+	aligned := make(map[string]interface{}, len(f.Assets))
+	for _, asset := range f.Assets {
+		if bar, ok := latest[asset]; ok {
+			aligned[asset] = bar
+			f.last[asset] = bar
+			continue
+		}
+
+		switch f.Missing {
+		case MissingDrop:
+			return nil
+		case MissingForwardFill:
+			if bar, ok := f.last[asset]; ok {
+				aligned[asset] = forwardFill(bar)
+			}
+		}
+	}
+	return aligned
+}
+
+// forwardFill returns a flat bar at the close of the last known bar
+func forwardFill(bar interface{}) interface{} {
+	ohlcv, ok := bar.(map[string]interface{})
+	if !ok {
+		return bar
+	}
+
+	filled := make(map[string]interface{}, len(ohlcv))
+	for key, value := range ohlcv {
+		filled[key] = value
+	}
+	if c, ok := ohlcv["c"]; ok {
+		filled["o"], filled["h"], filled["l"] = c, c, c
+	}
+	if _, ok := ohlcv["v"]; ok {
+		filled["v"] = 0
+	}
+	return filled
+}
+
+// dispatchEvents delivers queued runtime events to the strategy until it stops causing new ones
+func (r *Runner) dispatchEvents() {
+	for {
+		events := r.backtest.Drain()
+		if len(events) == 0 {
+			return
+		}
+
+		for _, event := range events {
+			r.strategy(event.Tradehook, event.Data)
+		}
+	}
+}
+
+// gcd returns the greatest common divisor of durations, zero is ignored
+func gcd(a, b time.Duration) time.Duration {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
@@ -0,0 +1,140 @@
+package backtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseResolution(t *testing.T) {
+	for resolution, expected := range map[string]time.Duration{
+		"1m":    time.Minute,
+		"5min":  5 * time.Minute,
+		"1h":    time.Hour,
+		"1d":    24 * time.Hour,
+		"1day":  24 * time.Hour,
+		"1week": 7 * 24 * time.Hour,
+	} {
+		step, err := ParseResolution(resolution)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, step, resolution)
+	}
+
+	for _, resolution := range []string{"", "day", "0d", "1y"} {
+		_, err := ParseResolution(resolution)
+		assert.Error(t, err, resolution)
+	}
+}
+
+func TestRunnerDeliversBarsAndEventsInOrder(t *testing.T) {
+	engine := NewEngine(testBars(), DefaultCash)
+	bt := NewBacktestWithTransport("2021-01-03 21:00:00.000000", "2021-01-07 21:00:00.000000", engine)
+
+	var tradehooks []string
+	strategy := func(tradehook string, payload []byte) {
+		tradehooks = append(tradehooks, tradehook)
+
+		if tradehook == "bar" && len(tradehooks) == 1 {
+			var p struct {
+				Assets []string                          `json:"assets"`
+				Bars   map[string]map[string]interface{} `json:"bars"`
+			}
+			assert.NoError(t, json.Unmarshal(payload, &p))
+			assert.Equal(t, []string{"AAPL"}, p.Assets)
+			assert.Contains(t, p.Bars, "2021-01-04T21:00:00")
+
+			data, _ := json.Marshal(map[string]interface{}{"asset": "AAPL", "side": "buy", "type": "market", "qty": 1})
+			req, _ := http.NewRequest(http.MethodPost, "/orders", bytes.NewBuffer(data))
+			res := bt.CallErocMethod(req)
+			assert.Equal(t, 201, res.StatusCode)
+		}
+	}
+
+	runner, err := NewRunner(bt, strategy, []string{"AAPL"}, "1day")
+	assert.NoError(t, err)
+	assert.NoError(t, runner.Run())
+
+	assert.Equal(t, []string{"bar", "order_accepted", "order_filled", "bar", "bar", "bar"}, tradehooks)
+	assert.Equal(t, 1, len(engine.Fills()))
+}
+
+func TestRunnerAlignsFeedsOfSeveralResolutions(t *testing.T) {
+	hour := func(h int) time.Time {
+		return time.Date(2021, 1, 4, h, 0, 0, 0, time.UTC)
+	}
+
+	// Hourly bars are dated by their close, so the day consists of bars from 01:00 to 24:00
+	bars := map[string][]Bar{}
+	for h := 1; h <= 24; h++ {
+		bars["AAPL"] = append(bars["AAPL"], Bar{Datetime: hour(h), Open: 100, High: 101, Low: 99, Close: 100, Volume: 10})
+		if h != 5 {
+			bars["MSFT"] = append(bars["MSFT"], Bar{Datetime: hour(h), Open: 200, High: 201, Low: 199, Close: 200, Volume: 10})
+		}
+	}
+
+	engine := NewEngine(bars, DefaultCash)
+	bt := NewBacktestWithTransport("2021-01-04 00:00:00.000000", "2021-01-05 00:00:00.000000", engine)
+
+	type barPayload struct {
+		Assets     []string                                     `json:"assets"`
+		Resolution string                                       `json:"resolution"`
+		Bars       map[string]map[string]map[string]interface{} `json:"bars"`
+	}
+
+	var payloads []barPayload
+	strategy := func(tradehook string, payload []byte) {
+		var p barPayload
+		assert.NoError(t, json.Unmarshal(payload, &p))
+		payloads = append(payloads, p)
+	}
+
+	runner, err := NewMultiRunner(bt, strategy,
+		Feed{Assets: []string{"AAPL"}, Resolution: "1day"},
+		Feed{Assets: []string{"AAPL", "MSFT"}, Resolution: "1h", Missing: MissingForwardFill},
+	)
+	assert.NoError(t, err)
+	assert.NoError(t, runner.Run())
+
+	var hourly, daily []barPayload
+	for _, p := range payloads {
+		if p.Resolution == "1h" {
+			hourly = append(hourly, p)
+		} else {
+			daily = append(daily, p)
+		}
+	}
+
+	// Neither feed has bars to deliver at the start
+	assert.Equal(t, 24, len(hourly))
+	assert.Equal(t, 1, len(daily))
+
+	filled := hourly[4].Bars["2021-01-04T05:00:00"]["MSFT"]
+	assert.Equal(t, 200.0, filled["o"])
+	assert.Equal(t, 0.0, filled["v"])
+
+	// The daily bar aggregates every hourly bar once and is delivered after the hourly bar at the same datetime
+	assert.Equal(t, "1day", payloads[len(payloads)-1].Resolution)
+	day := payloads[len(payloads)-1].Bars["2021-01-05T00:00:00"]["AAPL"]
+	assert.Equal(t, map[string]interface{}{"o": 100.0, "h": 101.0, "l": 99.0, "c": 100.0, "v": 240.0}, day)
+}
+
+func TestRunnerDropsBarsWithMissingAssets(t *testing.T) {
+	bars := testBars()
+	bars["MSFT"] = bars["AAPL"][1:]
+
+	engine := NewEngine(bars, DefaultCash)
+	bt := NewBacktestWithTransport("2021-01-03 21:00:00.000000", "2021-01-07 21:00:00.000000", engine)
+
+	var count int
+	strategy := func(tradehook string, payload []byte) {
+		count++
+	}
+
+	runner, err := NewMultiRunner(bt, strategy, Feed{Assets: []string{"AAPL", "MSFT"}, Resolution: "1day", Missing: MissingDrop})
+	assert.NoError(t, err)
+	assert.NoError(t, runner.Run())
+	assert.Equal(t, 3, count)
+}
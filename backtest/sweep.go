@@ -0,0 +1,199 @@
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// Params is a set of strategy parameters of a single backtest session
+type Params map[string]interface{}
+
+// ParamGrid maps parameter name to its candidate values
+type ParamGrid map[string][]interface{}
+
+// Reporter is implemented by transports which can evaluate the session, e.g. Engine
+type Reporter interface {
+	Report() (*Report, error)
+}
+
+// SweepResult is an outcome of a single backtest session
+type SweepResult struct {
+	Params Params  `json:"params"`
+	Report *Report `json:"report"`
+	Score  float64 `json:"score"`
+	Err    error   `json:"-"`
+}
+
+// Sweep runs the same strategy with many parameter sets in parallel isolated backtest sessions
+type Sweep struct {
+	// Start and End dates of every session
+	Start string
+	End   string
+
+	// Assets and Resolution of bars delivered to the strategy
+	Assets     []string
+	Resolution string
+
+	// Transport creates new EROC transport for every session, it should implement Reporter
+	Transport func() (Transport, error)
+
+	// Strategy creates strategy handler for the session; it must send requests using the session Backtest,
+	// e.g. through http.NewBacktestClient, instead of the global backtest mode
+	Strategy func(bt *Backtest, params Params) func(tradehook string, payload []byte)
+
+	// Score ranks sessions, higher is better; Sharpe ratio is used by default
+	Score func(r *Report) float64
+
+	// Workers is a number of sessions run at the same time; number of CPUs is used by default
+	Workers int
+}
+
+// Combinations returns all parameter sets of the grid
+func (g ParamGrid) Combinations() []Params {
+	names := g.names()
+	combinations := []Params{{}}
+
+	for _, name := range names {
+		next := make([]Params, 0, len(combinations)*len(g[name]))
+		for _, c := range combinations {
+			for _, v := range g[name] {
+				p := make(Params, len(c)+1)
+				for k, cv := range c {
+					p[k] = cv
+				}
+				p[name] = v
+				next = append(next, p)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// Sample returns n random parameter sets of the grid, the same seed gives the same sets
+func (g ParamGrid) Sample(n int, seed int64) []Params {
+	rng := rand.New(rand.NewSource(seed))
+	names := g.names()
+
+	samples := make([]Params, n)
+	for i := range samples {
+		p := make(Params, len(names))
+		for _, name := range names {
+			if values := g[name]; len(values) > 0 {
+				p[name] = values[rng.Intn(len(values))]
+			}
+		}
+		samples[i] = p
+	}
+	return samples
+}
+
+// names returns sorted parameter names
+func (g ParamGrid) names() []string {
+	names := make([]string, 0, len(g))
+	for name := range g {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run runs a session for every parameter set and returns results ranked by score,
+// failed sessions are placed last with Err set
+func (s *Sweep) Run(paramSets []Params) ([]SweepResult, error) {
+	if s.Transport == nil || s.Strategy == nil {
+		return nil, errors.New("transport and strategy are required")
+	}
+	if _, err := ParseResolution(s.Resolution); err != nil {
+		return nil, err
+	}
+
+	workers := s.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	results := make([]SweepResult, len(paramSets))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = s.runSession(paramSets[i])
+			}
+		}()
+	}
+
+	for i := range paramSets {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if (results[i].Err == nil) != (results[j].Err == nil) {
+			return results[i].Err == nil
+		}
+		return results[i].Score > results[j].Score
+	})
+	return results, nil
+}
+
+// runSession runs a single backtest session with its own transport, strategy panic fails only the session
+func (s *Sweep) runSession(params Params) (result SweepResult) {
+	result = SweepResult{Params: params}
+	defer func() {
+		if p := recover(); p != nil {
+			result = SweepResult{Params: params, Err: fmt.Errorf("strategy panic: %v", p)}
+		}
+	}()
+
+	transport, err := s.Transport()
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	bt := NewBacktestWithTransport(s.Start, s.End, transport)
+	defer bt.Close()
+
+	runner, err := NewRunner(bt, s.Strategy(bt, params), s.Assets, s.Resolution)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	if err = runner.Run(); err != nil {
+		result.Err = err
+		return result
+	}
+
+	reporter, ok := transport.(Reporter)
+	if !ok {
+		result.Err = errors.New("transport doesn't provide report")
+		return result
+	}
+
+	if result.Report, err = reporter.Report(); err != nil {
+		result.Err = err
+		return result
+	}
+
+	result.Score = s.score(result.Report)
+	return result
+}
+
+// score returns rank of the session report
+func (s *Sweep) score(r *Report) float64 {
+	if s.Score != nil {
+		return s.Score(r)
+	}
+	return r.Sharpe
+}
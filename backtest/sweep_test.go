@@ -0,0 +1,99 @@
+package backtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestParamGridCombinationsAndSample(t *testing.T) {
+	grid := ParamGrid{
+		"qty":  {1, 2, 3},
+		"side": {"buy", "sell"},
+	}
+
+	combinations := grid.Combinations()
+	assert.Equal(t, 6, len(combinations))
+	assert.Equal(t, Params{"qty": 1, "side": "buy"}, combinations[0])
+	assert.Equal(t, Params{"qty": 3, "side": "sell"}, combinations[5])
+
+	samples := grid.Sample(4, 42)
+	assert.Equal(t, 4, len(samples))
+	assert.Equal(t, samples, grid.Sample(4, 42))
+	for _, p := range samples {
+		assert.Contains(t, grid["qty"], p["qty"])
+		assert.Contains(t, grid["side"], p["side"])
+	}
+}
+
+func TestSweepRanksParallelSessions(t *testing.T) {
+	sweep := &Sweep{
+		Start:      "2021-01-04 21:00:00.000000",
+		End:        "2021-01-07 21:00:00.000000",
+		Assets:     []string{"AAPL"},
+		Resolution: "1day",
+		Transport: func() (Transport, error) {
+			return NewEngine(testBars(), DefaultCash), nil
+		},
+		Strategy: func(bt *Backtest, params Params) func(string, []byte) {
+			client := &http.Client{Transport: bt}
+			placed := false
+
+			return func(tradehook string, payload []byte) {
+				if tradehook != "bar" || placed {
+					return
+				}
+				placed = true
+
+				data, _ := json.Marshal(map[string]interface{}{"asset": "AAPL", "side": "buy", "type": "market", "qty": params["qty"]})
+				res, err := client.Post("/orders", "application/json", bytes.NewBuffer(data))
+				assert.NoError(t, err)
+				assert.Equal(t, 201, res.StatusCode)
+			}
+		},
+		Score: func(r *Report) float64 {
+			return r.TotalReturn
+		},
+		Workers: 2,
+	}
+
+	results, err := sweep.Run(ParamGrid{"qty": {1, 10, 100}}.Combinations())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(results))
+
+	assert.Equal(t, 1, results[0].Params["qty"])
+	assert.Equal(t, 10, results[1].Params["qty"])
+	assert.Equal(t, 100, results[2].Params["qty"])
+	assert.True(t, results[1].Score < results[0].Score)
+	assert.True(t, results[2].Score < results[1].Score)
+}
+
+func TestSweepFailsPanickingSession(t *testing.T) {
+	sweep := &Sweep{
+		Start:      "2021-01-04 21:00:00.000000",
+		End:        "2021-01-07 21:00:00.000000",
+		Assets:     []string{"AAPL"},
+		Resolution: "1day",
+		Transport: func() (Transport, error) {
+			return NewEngine(testBars(), DefaultCash), nil
+		},
+		Strategy: func(bt *Backtest, params Params) func(string, []byte) {
+			return func(tradehook string, payload []byte) {
+				if params["fail"] == true {
+					panic("boom")
+				}
+			}
+		},
+		Workers: 2,
+	}
+
+	results, err := sweep.Run(ParamGrid{"fail": {true, false}}.Combinations())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(results))
+
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, false, results[0].Params["fail"])
+	assert.EqualError(t, results[1].Err, "strategy panic: boom")
+}
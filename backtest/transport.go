@@ -0,0 +1,14 @@
+package backtest
+
+// Transport delivers EROC requests to the router and receives its responses.
+// ZmqConn talks to an external EROC router, Engine serves requests in-process.
+type Transport interface {
+	// SendJSON convert data to json and sends it to the router
+	SendJSON(src interface{}) error
+
+	// ReceiveJSON receives router response and parse JSON-encoded data into selected struct
+	ReceiveJSON(dst interface{}) error
+
+	// Close transport connection
+	Close()
+}
@@ -0,0 +1,233 @@
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// WalkForward optimizes strategy parameters on rolling in-sample windows and evaluates the best ones
+// on the following out-of-sample windows
+type WalkForward struct {
+	// Sweep runs sessions of every window, its Start and End dates are the whole analyzed range
+	Sweep *Sweep
+
+	// InSample and OutOfSample are window lengths
+	InSample    time.Duration
+	OutOfSample time.Duration
+
+	// Step is a shift between consecutive windows; OutOfSample is used by default
+	Step time.Duration
+
+	// Anchored keeps in-sample windows starting at the range start, so they grow with every step
+	Anchored bool
+}
+
+// WalkForwardWindow is an outcome of a single in-sample optimization and out-of-sample evaluation
+type WalkForwardWindow struct {
+	InSampleStart    time.Time `json:"in_sample_start"`
+	InSampleEnd      time.Time `json:"in_sample_end"`
+	OutOfSampleStart time.Time `json:"out_of_sample_start"`
+	OutOfSampleEnd   time.Time `json:"out_of_sample_end"`
+
+	Params           Params  `json:"params"`
+	InSample         *Report `json:"in_sample"`
+	OutOfSample      *Report `json:"out_of_sample"`
+	InSampleScore    float64 `json:"in_sample_score"`
+	OutOfSampleScore float64 `json:"out_of_sample_score"`
+}
+
+// WalkForwardResult is a combined out-of-sample report with overfitting diagnostics
+type WalkForwardResult struct {
+	Windows []WalkForwardWindow `json:"windows"`
+
+	// Report is built from stitched out-of-sample equity curves
+	Report *Report `json:"report"`
+
+	// Efficiency is an average out-of-sample annualized return relative to the in-sample one,
+	// values well below 1 indicate overfitting
+	Efficiency float64 `json:"efficiency"`
+
+	// Degradation is an average difference between in-sample and out-of-sample scores
+	Degradation float64 `json:"degradation"`
+
+	// Consistency is a share of windows with positive out-of-sample return
+	Consistency float64 `json:"consistency"`
+
+	// ParamStability is a share of windows which selected the same parameters as the previous one
+	ParamStability float64 `json:"param_stability"`
+}
+
+// Run optimizes parameter sets on every in-sample window and evaluates the best one out-of-sample
+func (w *WalkForward) Run(paramSets []Params) (*WalkForwardResult, error) {
+	if w.Sweep == nil {
+		return nil, errors.New("sweep is required")
+	}
+
+	windows, err := w.windows()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &WalkForwardResult{}
+	for _, window := range windows {
+		inSample, err := w.session(window.InSampleStart, window.InSampleEnd, paramSets)
+		if err != nil {
+			return nil, fmt.Errorf("in-sample %s: %w", window.InSampleStart.Format(DatetimeLayout), err)
+		}
+
+		outOfSample, err := w.session(window.OutOfSampleStart, window.OutOfSampleEnd, []Params{inSample.Params})
+		if err != nil {
+			return nil, fmt.Errorf("out-of-sample %s: %w", window.OutOfSampleStart.Format(DatetimeLayout), err)
+		}
+
+		window.Params = inSample.Params
+		window.InSample = inSample.Report
+		window.InSampleScore = inSample.Score
+		window.OutOfSample = outOfSample.Report
+		window.OutOfSampleScore = outOfSample.Score
+		result.Windows = append(result.Windows, window)
+	}
+
+	if result.Report, err = stitch(result.Windows); err != nil {
+		return nil, err
+	}
+	result.diagnose()
+
+	return result, nil
+}
+
+// windows splits the sweep range into in-sample and out-of-sample windows,
+// window ends are shifted by a bar so consecutive windows don't share bars
+func (w *WalkForward) windows() ([]WalkForwardWindow, error) {
+	if w.InSample <= 0 || w.OutOfSample <= 0 {
+		return nil, errors.New("in-sample and out-of-sample lengths must be positive")
+	}
+
+	start, err := ParseDatetime(w.Sweep.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, err := ParseDatetime(w.Sweep.End)
+	if err != nil {
+		return nil, err
+	}
+	bar, err := ParseResolution(w.Sweep.Resolution)
+	if err != nil {
+		return nil, err
+	}
+
+	step := w.Step
+	if step <= 0 {
+		step = w.OutOfSample
+	}
+
+	// Out-of-sample window needs at least 2 bars to be analyzed, a shorter tail is dropped
+	var windows []WalkForwardWindow
+	for s := start; !s.Add(w.InSample + bar).After(end); s = s.Add(step) {
+		window := WalkForwardWindow{
+			InSampleStart:    s,
+			InSampleEnd:      s.Add(w.InSample - bar),
+			OutOfSampleStart: s.Add(w.InSample),
+			OutOfSampleEnd:   s.Add(w.InSample + w.OutOfSample - bar),
+		}
+		if w.Anchored {
+			window.InSampleStart = start
+		}
+		if window.OutOfSampleEnd.After(end) {
+			window.OutOfSampleEnd = end
+		}
+		windows = append(windows, window)
+	}
+
+	if len(windows) == 0 {
+		return nil, errors.New("range is shorter than in-sample window")
+	}
+	return windows, nil
+}
+
+// session runs the sweep over selected dates and returns the best result
+func (w *WalkForward) session(start, end time.Time, paramSets []Params) (*SweepResult, error) {
+	sweep := *w.Sweep
+	sweep.Start = start.Format(DatetimeLayout)
+	sweep.End = end.Format(DatetimeLayout)
+
+	results, err := sweep.Run(paramSets)
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, errors.New("no parameter sets")
+	}
+	if results[0].Err != nil {
+		return nil, results[0].Err
+	}
+	return &results[0], nil
+}
+
+// stitch chains out-of-sample equity curves, so every window starts where the previous one ended,
+// and builds combined report
+func stitch(windows []WalkForwardWindow) (*Report, error) {
+	var equity []EquityPoint
+	var trades []Trade
+	var exposure, turnover float64
+	level := 0.0
+
+	for _, window := range windows {
+		r := window.OutOfSample
+		if level == 0 {
+			level = r.StartEquity
+		}
+
+		scale := level / r.StartEquity
+		for _, p := range r.Equity {
+			equity = append(equity, EquityPoint{Datetime: p.Datetime, Cash: p.Cash * scale, Equity: p.Equity * scale})
+		}
+		level = r.EndEquity * scale
+
+		trades = append(trades, r.Trades...)
+		exposure += r.Exposure * float64(len(r.Equity))
+		turnover += r.Turnover
+	}
+
+	report, err := Analyze(equity, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	report.Trades = trades
+	report.WinRate, report.ProfitFactor = tradeStats(trades)
+	report.Exposure = exposure / float64(len(equity))
+	report.Turnover = turnover
+	return report, nil
+}
+
+// diagnose calculates overfitting diagnostics of the windows
+func (r *WalkForwardResult) diagnose() {
+	var inSampleReturn, outOfSampleReturn float64
+	var positive, stable int
+
+	for i, window := range r.Windows {
+		inSampleReturn += window.InSample.AnnualizedReturn
+		outOfSampleReturn += window.OutOfSample.AnnualizedReturn
+		r.Degradation += window.InSampleScore - window.OutOfSampleScore
+
+		if window.OutOfSample.TotalReturn > 0 {
+			positive++
+		}
+		if i > 0 && reflect.DeepEqual(window.Params, r.Windows[i-1].Params) {
+			stable++
+		}
+	}
+
+	n := float64(len(r.Windows))
+	if inSampleReturn != 0 {
+		r.Efficiency = outOfSampleReturn / inSampleReturn
+	}
+	r.Degradation /= n
+	r.Consistency = float64(positive) / n
+	if len(r.Windows) > 1 {
+		r.ParamStability = float64(stable) / (n - 1)
+	}
+}
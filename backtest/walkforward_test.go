@@ -0,0 +1,134 @@
+package backtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func trendBars(days int) map[string][]Bar {
+	bars := make([]Bar, days)
+	for i := range bars {
+		price := 100 + float64(i) + 5*math.Sin(float64(i))
+		bars[i] = Bar{
+			Datetime: time.Date(2021, 1, 1+i, 21, 0, 0, 0, time.UTC),
+			Open:     price - 0.5, High: price + 1, Low: price - 1, Close: price, Volume: 1000,
+		}
+	}
+	return map[string][]Bar{"AAPL": bars}
+}
+
+func buyOnceStrategy(bt *Backtest, params Params) func(string, []byte) {
+	client := &http.Client{Transport: bt}
+	placed := false
+
+	return func(tradehook string, payload []byte) {
+		if tradehook != "bar" || placed {
+			return
+		}
+		placed = true
+
+		data, _ := json.Marshal(map[string]interface{}{"asset": "AAPL", "side": params["side"], "type": "market", "qty": 10})
+		res, err := client.Post("/orders", "application/json", bytes.NewBuffer(data))
+		if err == nil {
+			res.Body.Close()
+		}
+	}
+}
+
+func TestWalkForwardWindows(t *testing.T) {
+	wf := &WalkForward{
+		Sweep:       &Sweep{Start: "2021-01-01 21:00:00.000000", End: "2021-01-20 21:00:00.000000", Resolution: "1day"},
+		InSample:    10 * 24 * time.Hour,
+		OutOfSample: 5 * 24 * time.Hour,
+	}
+
+	windows, err := wf.windows()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(windows))
+	assert.Equal(t, "2021-01-10", windows[0].InSampleEnd.Format("2006-01-02"))
+	assert.Equal(t, "2021-01-11", windows[0].OutOfSampleStart.Format("2006-01-02"))
+	assert.Equal(t, "2021-01-15", windows[0].OutOfSampleEnd.Format("2006-01-02"))
+	assert.Equal(t, "2021-01-06", windows[1].InSampleStart.Format("2006-01-02"))
+	assert.Equal(t, "2021-01-20", windows[1].OutOfSampleEnd.Format("2006-01-02"))
+
+	wf.Anchored = true
+	windows, err = wf.windows()
+	assert.NoError(t, err)
+	assert.Equal(t, "2021-01-01", windows[1].InSampleStart.Format("2006-01-02"))
+
+	wf.InSample = 30 * 24 * time.Hour
+	_, err = wf.windows()
+	assert.Error(t, err)
+}
+
+func TestWalkForwardRun(t *testing.T) {
+	wf := &WalkForward{
+		Sweep: &Sweep{
+			Start:      "2021-01-01 21:00:00.000000",
+			End:        "2021-01-30 21:00:00.000000",
+			Assets:     []string{"AAPL"},
+			Resolution: "1day",
+			Transport: func() (Transport, error) {
+				return NewEngine(trendBars(30), DefaultCash), nil
+			},
+			Strategy: buyOnceStrategy,
+			Score: func(r *Report) float64 {
+				return r.TotalReturn
+			},
+		},
+		InSample:    10 * 24 * time.Hour,
+		OutOfSample: 5 * 24 * time.Hour,
+	}
+
+	result, err := wf.Run(ParamGrid{"side": {"buy", "sell"}}.Combinations())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(result.Windows))
+
+	for _, window := range result.Windows {
+		assert.Equal(t, "buy", window.Params["side"])
+		assert.True(t, window.InSampleScore > 0)
+	}
+
+	// Stitched curve is continuous between windows
+	first := result.Windows[0].OutOfSample
+	second := result.Windows[1].OutOfSample
+	assert.Equal(t, len(first.Equity)+len(second.Equity)+len(result.Windows[2].OutOfSample.Equity)+len(result.Windows[3].OutOfSample.Equity), len(result.Report.Equity))
+	assert.InDelta(t, first.EndEquity, result.Report.Equity[len(first.Equity)-1].Equity, 1e-6)
+	assert.InDelta(t, first.EndEquity, result.Report.Equity[len(first.Equity)].Equity, 1e-6)
+
+	assert.Equal(t, 1.0, result.ParamStability)
+	assert.True(t, result.Consistency > 0)
+	assert.NotEqual(t, 0.0, result.Efficiency)
+}
+
+func TestWalkForwardDropsShortTailWindow(t *testing.T) {
+	wf := &WalkForward{
+		Sweep: &Sweep{
+			Start:      "2021-01-01 21:00:00.000000",
+			End:        "2021-01-31 21:00:00.000000",
+			Assets:     []string{"AAPL"},
+			Resolution: "1day",
+			Transport: func() (Transport, error) {
+				return NewEngine(trendBars(31), DefaultCash), nil
+			},
+			Strategy: buyOnceStrategy,
+		},
+		InSample:    10 * 24 * time.Hour,
+		OutOfSample: 5 * 24 * time.Hour,
+	}
+
+	// Window starting on 2021-01-21 would have a single out-of-sample bar on the range end
+	windows, err := wf.windows()
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(windows))
+	assert.Equal(t, "2021-01-30", windows[3].OutOfSampleEnd.Format("2006-01-02"))
+
+	result, err := wf.Run(ParamGrid{"side": {"buy"}}.Combinations())
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(result.Windows))
+}
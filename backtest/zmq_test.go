@@ -69,7 +69,38 @@ func TestSendJZMQMessageAndRetrieveResponse(t *testing.T) {
 	assert.Equal(t, "", string(msg[0]), "invalid server message")
 }
 
-// TODO
 func TestSendZMQMessageJSONAndRetrieveResponse(t *testing.T) {
 
+	// Create server
+	router, err := NewMockRouter("tcp://*:3007")
+	if err != nil {
+		assert.NoError(t, err)
+	}
+	defer router.Close()
+
+	router.Respond("GET", "/accounts", &ErocResponse{Status: 200, Data: "world"})
+
+	// Create client
+	clientZMQ, err := NewZmq("tcp://127.0.0.1:3007")
+	if err != nil {
+		assert.NoError(t, err)
+	}
+	defer clientZMQ.Close()
+
+	// Send message from client to server
+	err = clientZMQ.SendJSON(&ErocRequest{Method: "GET", Url: "/accounts"})
+	if err != nil {
+		assert.NoError(t, err)
+	}
+
+	// Receive message from server
+	var res ErocResponse
+	err = clientZMQ.ReceiveJSON(&res)
+	if err != nil {
+		assert.NoError(t, err)
+	}
+
+	assert.Equal(t, 200, res.Status, "invalid server message")
+	assert.Equal(t, "world", res.Data, "invalid server message")
+	router.Verify(t)
 }
@@ -0,0 +1,494 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/tradologics/go-sdk/backtest"
+	"io/ioutil"
+	_http "net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheDayLayout is a name layout of daily cache partitions
+const cacheDayLayout = "2006-01-02"
+
+// BarsFetcher downloads bars of the asset and resolution between start and end inclusive
+// in API shape: {datetime: {asset: {o, h, l, c, v}}}
+type BarsFetcher func(asset, resolution string, start, end time.Time) (map[string]interface{}, error)
+
+// BarCache keeps bars downloaded from the market data API in files partitioned by asset, resolution
+// and UTC date. Bar keys without timezone are read in the location of the requested range. Only missing ranges are downloaded; bars after the cache clock at download time,
+// the current bar datetime in backtest mode or wall time otherwise, may still change and are downloaded
+// again on the next request
+type BarCache struct {
+	mu    sync.Mutex
+	dir   string
+	fetch BarsFetcher
+	now   func() time.Time
+}
+
+// cachePartition is a content of a single day file. Covered holds final ranges of a partly cached day
+type cachePartition struct {
+	Complete bool                   `json:"complete"`
+	Covered  [][2]time.Time         `json:"covered,omitempty"`
+	Bars     map[string]interface{} `json:"bars"`
+}
+
+// Cache is used by GetBars when set by SetBarCache
+var Cache *BarCache
+
+// BarCacheOption configures BarCache
+type BarCacheOption func(*BarCache)
+
+// WithCacheClock sets the cache clock, bars after it at download time aren't final and are downloaded again
+func WithCacheClock(now func() time.Time) BarCacheOption {
+	return func(c *BarCache) {
+		c.now = now
+	}
+}
+
+// NewBarCache create new BarCache stored in selected directory which downloads missing bars
+// with the fetcher. Bars API and clock of DefaultClient are used if fetcher is nil, the clock
+// of other fetchers is wall time unless it's set by WithCacheClock
+func NewBarCache(dir string, fetch BarsFetcher, opts ...BarCacheOption) (*BarCache, error) {
+	if dir == "" {
+		return nil, errors.New("cache directory is required")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	c := &BarCache{dir: dir, fetch: fetch, now: time.Now}
+	if fetch == nil {
+		c.fetch, c.now = DefaultClient.fetchBars, DefaultClient.now
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// SetBarCache turn on caching of bars requested with GetBars in selected directory
+func SetBarCache(dir string) error {
+	cache, err := NewBarCache(dir, nil)
+	if err != nil {
+		return err
+	}
+
+	Cache = cache
+	return nil
+}
+
+// Bars returns bars of selected assets and resolution between start and end inclusive in API shape,
+// missing ranges are downloaded and stored first
+func (c *BarCache) Bars(assets []string, resolution string, start, end time.Time) (map[string]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	bars := make(map[string]interface{})
+	for _, asset := range assets {
+		for _, gap := range c.gaps(asset, resolution, start, end) {
+			// Gaps of whole days start at UTC midnight, bar keys are read in the requested location
+			if err := c.fill(asset, resolution, gap[0].In(start.Location()), gap[1].In(start.Location())); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, day := range days(start, end) {
+			partition, err := c.read(asset, resolution, day)
+			if err != nil {
+				return nil, err
+			}
+			if partition == nil {
+				continue
+			}
+
+			for key, assetBars := range partition.Bars {
+				dt, err := time.ParseInLocation(backtest.BarsKeyLayout, key, start.Location())
+				if err != nil || dt.Before(start) || dt.After(end) {
+					continue
+				}
+				if _, ok := bars[key]; !ok {
+					bars[key] = make(map[string]interface{})
+				}
+				if m, ok := assetBars.(map[string]interface{}); ok {
+					bars[key].(map[string]interface{})[asset] = m[asset]
+				}
+			}
+		}
+	}
+	return bars, nil
+}
+
+// Gaps returns ranges between start and end which aren't cached yet or weren't final at download time
+func (c *BarCache) Gaps(asset, resolution string, start, end time.Time) [][2]time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.gaps(asset, resolution, start, end)
+}
+
+// Invalidate removes cached days of the asset and resolution between start and end inclusive;
+// empty resolution removes every resolution and empty asset every asset
+func (c *BarCache) Invalidate(asset, resolution string, start, end time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	assetPattern, resolutionPattern := url.PathEscape(asset), url.PathEscape(resolution)
+	if assetPattern == "" {
+		assetPattern = "*"
+	}
+	if resolutionPattern == "" {
+		resolutionPattern = "*"
+	}
+
+	for _, day := range days(start, end) {
+		paths, err := filepath.Glob(filepath.Join(c.dir, assetPattern, resolutionPattern, day.Format(cacheDayLayout)+".json"))
+		if err != nil {
+			return err
+		}
+		for _, path := range paths {
+			if err = os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Clear removes every cached bar
+func (c *BarCache) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err = os.RemoveAll(filepath.Join(c.dir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Source returns backtest data source of selected assets and resolution read through the cache,
+// so backtests on the local engine don't download the same bars again
+func (c *BarCache) Source(assets []string, resolution string) backtest.DataSource {
+	return &cacheSource{cache: c, assets: assets, resolution: resolution}
+}
+
+// gaps returns merged ranges between start and end which have to be downloaded
+func (c *BarCache) gaps(asset, resolution string, start, end time.Time) [][2]time.Time {
+	var gaps [][2]time.Time
+	for _, day := range days(start, end) {
+		var covered [][2]time.Time
+		partition, err := c.read(asset, resolution, day)
+		if err == nil && partition != nil {
+			if partition.Complete {
+				continue
+			}
+			covered = partition.Covered
+		}
+
+		from, to := clip(day, start, end)
+		for _, gap := range subtractRanges(from, to, covered) {
+			if n := len(gaps); n > 0 && gaps[n-1][1].Add(time.Microsecond).Equal(gap[0]) {
+				gaps[n-1][1] = gap[1]
+				continue
+			}
+			gaps = append(gaps, gap)
+		}
+	}
+	return gaps
+}
+
+// fill downloads bars between start and end and stores them. Bars up to the end or the cache clock
+// at download time, whichever is earlier, are final, so that part of the range is marked as covered
+func (c *BarCache) fill(asset, resolution string, start, end time.Time) error {
+	final := c.now()
+	if end.Before(final) {
+		final = end
+	}
+
+	bars, err := c.fetch(asset, resolution, start, end)
+	if err != nil {
+		return err
+	}
+
+	partitions := make(map[string]*cachePartition)
+	for _, day := range days(start, end) {
+		partition, err := c.read(asset, resolution, day)
+		if err != nil {
+			return err
+		}
+		if partition == nil {
+			partition = &cachePartition{}
+		}
+		if partition.Bars == nil {
+			partition.Bars = make(map[string]interface{})
+		}
+
+		if from, to := clip(day, start, final); !to.Before(from) {
+			partition.Covered = mergeRanges(append(partition.Covered, [2]time.Time{from, to}))
+		}
+		if len(subtractRanges(day, dayEnd(day), partition.Covered)) == 0 {
+			partition.Complete, partition.Covered = true, nil
+		}
+		partitions[day.Format(cacheDayLayout)] = partition
+	}
+
+	for key, assetBars := range bars {
+		dt, err := time.ParseInLocation(backtest.BarsKeyLayout, key, start.Location())
+		if err != nil {
+			return fmt.Errorf("invalid bar datetime %q", key)
+		}
+		if m, ok := assetBars.(map[string]interface{}); ok {
+			if _, ok = m[asset]; !ok {
+				continue
+			}
+			if partition, ok := partitions[dt.UTC().Format(cacheDayLayout)]; ok {
+				partition.Bars[key] = map[string]interface{}{asset: m[asset]}
+			}
+		}
+	}
+
+	for day, partition := range partitions {
+		if err = c.write(asset, resolution, day, partition); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// read returns cached day or nil if it isn't cached
+func (c *BarCache) read(asset, resolution string, day time.Time) (*cachePartition, error) {
+	data, err := ioutil.ReadFile(c.path(asset, resolution, day.Format(cacheDayLayout)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var partition cachePartition
+	if err = json.Unmarshal(data, &partition); err != nil {
+		// Corrupted day is downloaded again
+		return nil, nil
+	}
+	return &partition, nil
+}
+
+// write stores the day atomically, so interrupted writes don't leave corrupted files
+func (c *BarCache) write(asset, resolution, day string, partition *cachePartition) error {
+	path := c.path(asset, resolution, day)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(partition)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// path returns file path of the day
+func (c *BarCache) path(asset, resolution, day string) string {
+	return filepath.Join(c.dir, url.PathEscape(asset), url.PathEscape(resolution), day+".json")
+}
+
+// days returns UTC midnights of every day between start and end inclusive
+func days(start, end time.Time) []time.Time {
+	var result []time.Time
+	day := start.UTC().Truncate(24 * time.Hour)
+	for ; !day.After(end); day = day.AddDate(0, 0, 1) {
+		result = append(result, day)
+	}
+	return result
+}
+
+// dayEnd returns the last microsecond of the day
+func dayEnd(day time.Time) time.Time {
+	return day.AddDate(0, 0, 1).Add(-time.Microsecond)
+}
+
+// clip returns part of the day between start and end, it's empty if to is before from
+func clip(day, start, end time.Time) (from, to time.Time) {
+	from, to = day, dayEnd(day)
+	if start.After(from) {
+		from = start
+	}
+	if end.Before(to) {
+		to = end
+	}
+	return from, to
+}
+
+// mergeRanges returns ranges sorted by start with overlapping and adjacent ones joined
+func mergeRanges(ranges [][2]time.Time) [][2]time.Time {
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i][0].Before(ranges[j][0])
+	})
+
+	var merged [][2]time.Time
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && !r[0].After(merged[n-1][1].Add(time.Microsecond)) {
+			if r[1].After(merged[n-1][1]) {
+				merged[n-1][1] = r[1]
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// subtractRanges returns parts of the range between from and to which aren't covered by merged ranges
+func subtractRanges(from, to time.Time, covered [][2]time.Time) [][2]time.Time {
+	var parts [][2]time.Time
+	for _, r := range covered {
+		if to.Before(from) {
+			break
+		}
+		if r[1].Before(from) || r[0].After(to) {
+			continue
+		}
+		if r[0].After(from) {
+			parts = append(parts, [2]time.Time{from, r[0].Add(-time.Microsecond)})
+		}
+		from = r[1].Add(time.Microsecond)
+	}
+	if !to.Before(from) {
+		parts = append(parts, [2]time.Time{from, to})
+	}
+	return parts
+}
+
+// cacheSource is a backtest data source reading bars through BarCache
+type cacheSource struct {
+	cache      *BarCache
+	assets     []string
+	resolution string
+}
+
+// Bars returns cached bars of the source assets between start and end inclusive
+func (s *cacheSource) Bars(start, end time.Time) (map[string][]backtest.Bar, error) {
+	payload, err := s.cache.Bars(s.assets, s.resolution, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	bars := make(map[string][]backtest.Bar)
+	for key, assetBars := range payload {
+		dt, err := time.ParseInLocation(backtest.BarsKeyLayout, key, start.Location())
+		if err != nil {
+			return nil, err
+		}
+
+		for asset, value := range assetBars.(map[string]interface{}) {
+			ohlcv, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			field := func(name string) float64 {
+				f, _ := strconv.ParseFloat(fmt.Sprint(ohlcv[name]), 64)
+				return f
+			}
+			bars[asset] = append(bars[asset], backtest.Bar{
+				Datetime: dt,
+				Open:     field("o"),
+				High:     field("h"),
+				Low:      field("l"),
+				Close:    field("c"),
+				Volume:   field("v"),
+			})
+		}
+	}
+
+	for _, assetBars := range bars {
+		sort.Slice(assetBars, func(i, j int) bool {
+			return assetBars[i].Datetime.Before(assetBars[j].Datetime)
+		})
+	}
+	return bars, nil
+}
+
+// GetBars returns bars of selected assets and resolution between start and end using default client,
+// through the cache if it's set by SetBarCache
+func GetBars(assets []string, resolution string, start, end time.Time) (map[string]interface{}, error) {
+	if Cache != nil {
+		return Cache.Bars(assets, resolution, start, end)
+	}
+	return DefaultClient.GetBars(assets, resolution, start, end)
+}
+
+// GetBars returns bars of selected assets and resolution between start and end in API shape:
+// {datetime: {asset: {o, h, l, c, v}}}
+func (c *Client) GetBars(assets []string, resolution string, start, end time.Time) (map[string]interface{}, error) {
+	query := url.Values{}
+	query.Set("assets", strings.Join(assets, ","))
+	query.Set("resolution", resolution)
+	query.Set("start", start.Format(backtest.DatetimeLayout))
+	query.Set("end", end.Format(backtest.DatetimeLayout))
+
+	res, err := c.Get("/bars?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var data struct {
+		Errors []backtest.ErocError   `json:"errors"`
+		Data   map[string]interface{} `json:"data"`
+	}
+	if err = json.Unmarshal(body, &data); err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != _http.StatusOK {
+		if len(data.Errors) > 0 {
+			return nil, fmt.Errorf("bars request failed: %s", data.Errors[0].Message)
+		}
+		return nil, fmt.Errorf("bars request failed: %s", res.Status)
+	}
+	if data.Data == nil {
+		data.Data = make(map[string]interface{})
+	}
+	return data.Data, nil
+}
+
+// now returns datetime of the current bar in backtest mode and wall time otherwise, bars after it may still change
+func (c *Client) now() time.Time {
+	if bt := c.backtestSession(); bt != nil {
+		if dt, ok := bt.CurrentDatetime(); ok {
+			return dt
+		}
+	}
+	return time.Now()
+}
+
+// fetchBars is a BarsFetcher using bars API of the client
+func (c *Client) fetchBars(asset, resolution string, start, end time.Time) (map[string]interface{}, error) {
+	return c.GetBars([]string{asset}, resolution, start, end)
+}
@@ -0,0 +1,167 @@
+package http
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/tradologics/go-sdk/backtest"
+	"testing"
+	"time"
+)
+
+type fetchCall struct {
+	asset      string
+	start, end time.Time
+}
+
+// hourlyFetcher returns hourly bars of every whole hour between start and end and records calls
+func hourlyFetcher(calls *[]fetchCall) BarsFetcher {
+	return func(asset, resolution string, start, end time.Time) (map[string]interface{}, error) {
+		*calls = append(*calls, fetchCall{asset, start, end})
+
+		dt := start.Truncate(time.Hour)
+		if dt.Before(start) {
+			dt = dt.Add(time.Hour)
+		}
+
+		bars := make(map[string]interface{})
+		for ; !dt.After(end); dt = dt.Add(time.Hour) {
+			bars[dt.Format(backtest.BarsKeyLayout)] = map[string]interface{}{
+				asset: map[string]interface{}{"o": 1.0, "h": 2.0, "l": 0.5, "c": 1.5, "v": 100.0},
+			}
+		}
+		return bars, nil
+	}
+}
+
+func TestBarCacheFillsOnlyGaps(t *testing.T) {
+	var calls []fetchCall
+	cache, err := NewBarCache(t.TempDir(), hourlyFetcher(&calls))
+	assert.NoError(t, err)
+	cache.now = func() time.Time {
+		return time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	day := func(d int) time.Time {
+		return time.Date(2021, 1, d, 0, 0, 0, 0, time.UTC)
+	}
+
+	bars, err := cache.Bars([]string{"AAPL"}, "1h", day(4), day(5).Add(23*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 48, len(bars))
+	assert.Equal(t, []fetchCall{{"AAPL", day(4), day(5).Add(23 * time.Hour)}}, calls)
+	assert.Empty(t, cache.Gaps("AAPL", "1h", day(4), day(5)))
+
+	// Cached ranges are not downloaded again, only the missing ones
+	bars, err = cache.Bars([]string{"AAPL"}, "1h", day(5), day(7).Add(12*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 24+24+13, len(bars))
+	assert.Equal(t, 2, len(calls))
+	assert.Equal(t, day(5).Add(23*time.Hour+time.Microsecond), calls[1].start)
+	assert.Equal(t, day(7).Add(12*time.Hour), calls[1].end)
+	assert.Equal(t, [][2]time.Time{{day(7).Add(12*time.Hour + time.Microsecond), day(7).Add(18 * time.Hour)}},
+		cache.Gaps("AAPL", "1h", day(6), day(7).Add(18*time.Hour)))
+
+	// Invalidated day is downloaded again
+	assert.NoError(t, cache.Invalidate("AAPL", "", day(5), day(5)))
+	assert.Equal(t, [][2]time.Time{{day(5), day(6).Add(-time.Microsecond)}}, cache.Gaps("AAPL", "1h", day(4), day(7)))
+
+	assert.NoError(t, cache.Clear())
+	assert.Equal(t, 1, len(cache.Gaps("AAPL", "1h", day(4), day(7))))
+}
+
+func TestBarCacheRefetchesBarsAfterClock(t *testing.T) {
+	var calls []fetchCall
+	cache, err := NewBarCache(t.TempDir(), hourlyFetcher(&calls))
+	assert.NoError(t, err)
+
+	now := time.Date(2021, 1, 4, 12, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time {
+		return now
+	}
+
+	start := time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)
+	end := start.Add(23 * time.Hour)
+	_, err = cache.Bars([]string{"AAPL"}, "1h", start, end)
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]time.Time{{now.Add(time.Microsecond), end}}, cache.Gaps("AAPL", "1h", start, end))
+
+	// Bars after the clock at download time are downloaded again
+	later := now
+	now = now.Add(24 * time.Hour)
+	_, err = cache.Bars([]string{"AAPL"}, "1h", start, end)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(calls))
+	assert.Equal(t, fetchCall{"AAPL", later.Add(time.Microsecond), end}, calls[1])
+	assert.Empty(t, cache.Gaps("AAPL", "1h", start, end))
+}
+
+func TestBarCacheInBacktestMode(t *testing.T) {
+	start := time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)
+
+	var hourly []backtest.Bar
+	for h := 0; h < 24; h++ {
+		hourly = append(hourly, backtest.Bar{Datetime: start.Add(time.Duration(h) * time.Hour), Open: 1, High: 2, Low: 0.5, Close: 1.5, Volume: 100})
+	}
+	bt := backtest.NewBacktestWithTransport("2021-01-04 00:00:00.000000", "2021-01-05 00:00:00.000000",
+		backtest.NewEngine(map[string][]backtest.Bar{"AAPL": hourly}, backtest.DefaultCash))
+	client := NewBacktestClient(bt)
+
+	cache, err := NewBarCache(t.TempDir(), client.fetchBars)
+	assert.NoError(t, err)
+	cache.now = client.now
+
+	// Intraday request isn't extended to the whole day, which would reach past the current bar
+	current := start.Add(12 * time.Hour)
+	bt.SetCurrentBarInfo(&backtest.BarInfo{Datetime: current.Format(backtest.DatetimeLayout), Resolution: "1hour"})
+	bars, err := cache.Bars([]string{"AAPL"}, "1hour", start, current)
+	assert.NoError(t, err)
+	assert.Equal(t, 13, len(bars))
+	assert.Empty(t, cache.Gaps("AAPL", "1hour", start, current))
+
+	// Bars up to the current bar are final in backtest mode
+	current = start.Add(18 * time.Hour)
+	bt.SetCurrentBarInfo(&backtest.BarInfo{Datetime: current.Format(backtest.DatetimeLayout), Resolution: "1hour"})
+	assert.Equal(t, [][2]time.Time{{start.Add(12*time.Hour + time.Microsecond), current}}, cache.Gaps("AAPL", "1hour", start, current))
+
+	bars, err = cache.Bars([]string{"AAPL"}, "1hour", start, current)
+	assert.NoError(t, err)
+	assert.Equal(t, 19, len(bars))
+}
+
+func TestBarCacheReadsBarsInRequestedLocation(t *testing.T) {
+	location, err := time.LoadLocation("America/New_York")
+	assert.NoError(t, err)
+
+	var calls []fetchCall
+	cache, err := NewBarCache(t.TempDir(), hourlyFetcher(&calls), WithCacheClock(func() time.Time {
+		return time.Date(2021, 2, 1, 0, 0, 0, 0, time.UTC)
+	}))
+	assert.NoError(t, err)
+
+	start := time.Date(2021, 1, 4, 9, 0, 0, 0, location)
+	end := start.Add(3 * time.Hour)
+	bars, err := cache.Source([]string{"AAPL"}, "1h").Bars(start, end)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(bars["AAPL"]))
+	assert.True(t, start.Equal(bars["AAPL"][0].Datetime))
+	assert.True(t, end.Equal(bars["AAPL"][3].Datetime))
+
+	// Cached bars are read in the same location
+	bars, err = cache.Source([]string{"AAPL"}, "1h").Bars(start, end)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, len(bars["AAPL"]))
+	assert.Equal(t, 1, len(calls))
+}
+
+func TestBarCacheSource(t *testing.T) {
+	var calls []fetchCall
+	cache, err := NewBarCache(t.TempDir(), hourlyFetcher(&calls))
+	assert.NoError(t, err)
+
+	start := time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)
+	bars, err := cache.Source([]string{"AAPL", "MSFT"}, "1h").Bars(start, start.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(bars["AAPL"]))
+	assert.Equal(t, 3, len(bars["MSFT"]))
+	assert.Equal(t, start, bars["AAPL"][0].Datetime)
+	assert.Equal(t, 1.5, bars["MSFT"][2].Close)
+}
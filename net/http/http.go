@@ -10,13 +10,13 @@ import (
 	_http "net/http"
 	"net/url"
 	"strings"
+	"time"
 )
 
 const (
 	baseHost       = "api.tradologics.com"
 	baseSchema     = "https"
 	basePath       = "/v1"
-	socketUrl      = "tcp://0.0.0.0:3003"
 	defaultTimeout = 5
 )
 
@@ -79,13 +79,13 @@ func (c *Client) processRequest(method, url, contentType string, body io.Reader,
 		return httpDefaultClient.Do(req)
 	}
 
-	if IsBacktest {
+	if bt := c.backtestSession(); bt != nil {
 		req, err := newRequestWithContentType(method, url, contentType, body)
 		if err != nil {
 			return nil, err
 		}
 
-		res := Backtest.CallErocMethod(req)
+		res := bt.CallErocMethod(req)
 
 		return res, nil
 	}
@@ -118,6 +118,25 @@ func (c *Client) processRequest(method, url, contentType string, body io.Reader,
 	return r, nil
 }
 
+// backtestSession returns Backtest used by the client: its own one if the client was created by NewBacktestClient,
+// or the global one if backtest mode is turned on
+func (c *Client) backtestSession() *backtest.Backtest {
+	if bt, ok := c.Transport.(*backtest.Backtest); ok {
+		return bt
+	}
+	if IsBacktest {
+		return Backtest
+	}
+	return nil
+}
+
+// NewBacktestClient returns new HTTP client which proxies requests to selected Backtest instead of the global one,
+// useful to run several backtest sessions in parallel. Sessions on a local data source have no bars before start
+// unless they're created with backtest.WithWarmup
+func NewBacktestClient(bt *backtest.Backtest) *Client {
+	return &Client{Timeout: defaultTimeout, Transport: bt}
+}
+
 // Head issues a HEAD to the specified URL using default client
 func Head(url string) (resp *_http.Response, err error) {
 	return DefaultClient.Head(url)
@@ -163,13 +182,27 @@ func SetToken(token string) {
 	Token = token
 }
 
-// SetBacktestMode turn on backtest mode
+// SetBacktestMode turn on backtest mode; start and end are sent to the EROC router as is
 func SetBacktestMode(start, end string) (err error) {
-	Backtest, err = backtest.NewBacktest(start, end, socketUrl)
+	Backtest, err = backtest.NewBacktest(start, end, backtest.DefaultSocketURL)
+	if err != nil {
+		return err
+	}
+
+	IsBacktest = true
+
+	return nil
+}
+
+// SetBacktestModeWithOptions turn on backtest mode with selected session options, e.g. socket URL,
+// initial capital or local data source. Options are validated before the backtest mode is turned on
+func SetBacktestModeWithOptions(start, end time.Time, opts ...backtest.Option) error {
+	bt, err := backtest.NewBacktestWithOptions(start, end, opts...)
 	if err != nil {
 		return err
 	}
 
+	Backtest = bt
 	IsBacktest = true
 
 	return nil
@@ -192,3 +225,35 @@ func GetRuntimeEvents() (map[string]interface{}, error) {
 	}
 	return nil, errors.New("please set backtest mode first")
 }
+
+// DrainRuntimeEvents returns all Backtest runtime events queued since the previous call
+func DrainRuntimeEvents() ([]backtest.RuntimeEvent, error) {
+	if Backtest != nil {
+		return Backtest.Drain(), nil
+	}
+	return nil, errors.New("please set backtest mode first")
+}
+
+// SubscribeRuntimeEvents registers handler called with every new Backtest runtime event;
+// returns a function which removes the handler
+func SubscribeRuntimeEvents(handler func(event backtest.RuntimeEvent)) (func(), error) {
+	if Backtest != nil {
+		return Backtest.Subscribe(handler), nil
+	}
+	return nil, errors.New("please set backtest mode first")
+}
+
+// RunBacktest steps bars of selected assets and resolution between backtest start and end dates
+// and delivers them along with runtime events to the strategy. Sessions on a local data source
+// have no bars before start unless they're created with backtest.WithWarmup
+func RunBacktest(strategy func(tradehook string, payload []byte), assets []string, resolution string) error {
+	if Backtest == nil {
+		return errors.New("please set backtest mode first")
+	}
+
+	runner, err := backtest.NewRunner(Backtest, strategy, assets, resolution)
+	if err != nil {
+		return err
+	}
+	return runner.Run()
+}
@@ -0,0 +1,319 @@
+package portfolio
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/tradologics/go-sdk/backtest"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// epsilon is a quantity treated as zero
+const epsilon = 1e-9
+
+// DefaultCostTolerance is a relative difference of average costs Reconcile doesn't report as a drift,
+// a basis point absorbs rounding of average prices by brokers
+const DefaultCostTolerance = 1e-4
+
+// Position is an asset holding of an account
+type Position struct {
+	AccountID   string  `json:"account_id"`
+	Asset       string  `json:"asset"`
+	Qty         float64 `json:"qty"`
+	AvgCost     float64 `json:"avg_cost"`
+	MarketPrice float64 `json:"market_price"`
+	RealizedPL  float64 `json:"realized_pl"`
+}
+
+// MarketValue returns position value using last known asset price
+func (p *Position) MarketValue() float64 {
+	return p.Qty * p.MarketPrice
+}
+
+// UnrealizedPL returns profit or loss of the open quantity using last known asset price
+func (p *Position) UnrealizedPL() float64 {
+	if p.MarketPrice == 0 {
+		return 0
+	}
+	return (p.MarketPrice - p.AvgCost) * p.Qty
+}
+
+// account is a cash balance and positions of a single account
+type account struct {
+	cash      float64
+	positions map[string]*Position
+}
+
+// orderFill is a fill progress of an order already applied to the ledger
+type orderFill struct {
+	qty        float64
+	value      float64
+	commission float64
+}
+
+// Ledger is a client-side view of strategy holdings. It consumes order fill tradehooks and keeps positions,
+// average cost, realized and unrealized P&L and cash per account
+type Ledger struct {
+	mu            sync.Mutex
+	accounts      map[string]*account
+	orders        map[string]orderFill
+	prices        map[string]float64
+	costTolerance float64
+}
+
+// NewLedger create new empty Ledger
+func NewLedger() *Ledger {
+	return &Ledger{
+		accounts:      make(map[string]*account),
+		orders:        make(map[string]orderFill),
+		prices:        make(map[string]float64),
+		costTolerance: DefaultCostTolerance,
+	}
+}
+
+// SetCash sets cash balance of the account
+func (l *Ledger) SetCash(accountID string, cash float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.account(accountID).cash = cash
+}
+
+// SetCostTolerance sets relative difference of average costs Reconcile doesn't report as a drift,
+// DefaultCostTolerance is used by default
+func (l *Ledger) SetCostTolerance(tolerance float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.costTolerance = tolerance
+}
+
+// SetPrice sets last known asset price used to value positions
+func (l *Ledger) SetPrice(asset string, price float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prices[asset] = price
+}
+
+// Handle applies tradehook to the ledger: fills of "order" tradehooks and of "order_filled" and
+// "order_partially_filled" runtime events update positions and cash, close prices of "bar" update market
+// prices, other tradehooks are ignored
+func (l *Ledger) Handle(tradehook string, payload []byte) error {
+	switch tradehook {
+	case "order", "order_filled", "order_partially_filled":
+		var order backtest.Order
+		if err := json.Unmarshal(payload, &order); err != nil {
+			return fmt.Errorf("invalid %s payload: %v", tradehook, err)
+		}
+		// "order" tradehook carries every status, orders without fills have nothing to apply
+		if tradehook == "order" && order.FilledQty <= epsilon {
+			return nil
+		}
+		return l.ApplyOrder(&order)
+	case "bar":
+		return l.applyBar(payload)
+	}
+	return nil
+}
+
+// HandleEvent applies backtest runtime event to the ledger
+func (l *Ledger) HandleEvent(event backtest.RuntimeEvent) error {
+	return l.Handle(event.Tradehook, event.Data)
+}
+
+// Wrap returns strategy handler which updates the ledger before calling the strategy,
+// so the strategy sees holdings including the tradehook it handles
+func (l *Ledger) Wrap(strategy func(tradehook string, payload []byte)) func(tradehook string, payload []byte) {
+	return func(tradehook string, payload []byte) {
+		// Malformed payloads are still delivered to the strategy which reports them its own way
+		_ = l.Handle(tradehook, payload)
+		strategy(tradehook, payload)
+	}
+}
+
+// ApplyOrder applies quantity of the order filled since it was applied last time. Fill tradehooks carry
+// cumulative filled quantity, average price and commission, so repeated tradehooks are applied once;
+// commission is charged from cash
+func (l *Ledger) ApplyOrder(order *backtest.Order) error {
+	if order.OrderID == "" {
+		return fmt.Errorf("order of %s has no id", order.Asset)
+	}
+	if order.AvgFillPrice == nil {
+		return fmt.Errorf("order %s has no fill price", order.OrderID)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	prev := l.orders[order.OrderID]
+	qty := order.FilledQty - prev.qty
+	if qty <= epsilon {
+		return nil
+	}
+
+	value := order.FilledQty * *order.AvgFillPrice
+	l.orders[order.OrderID] = orderFill{qty: order.FilledQty, value: value, commission: order.Commission}
+
+	l.fill(order.AccountID, order.Asset, order.Side, qty, (value-prev.value)/qty, order.Commission-prev.commission)
+	return nil
+}
+
+// Fill applies a single execution to the account
+func (l *Ledger) Fill(accountID, asset, side string, qty, price float64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.fill(accountID, asset, side, qty, price, 0)
+}
+
+// fill updates position using average cost method and cash of the account, which is charged the commission
+func (l *Ledger) fill(accountID, asset, side string, qty, price, commission float64) {
+	acc := l.account(accountID)
+	pos, ok := acc.positions[asset]
+	if !ok {
+		pos = &Position{AccountID: accountID, Asset: asset}
+		acc.positions[asset] = pos
+	}
+
+	signed := qty
+	if side == backtest.OrderSideSell {
+		signed = -qty
+	}
+	acc.cash -= signed*price + commission
+	l.prices[asset] = price
+
+	switch {
+	case pos.Qty == 0 || (pos.Qty > 0) == (signed > 0):
+		// Opening or increasing position
+		pos.AvgCost = (pos.AvgCost*math.Abs(pos.Qty) + price*qty) / (math.Abs(pos.Qty) + qty)
+		pos.Qty += signed
+	case qty <= math.Abs(pos.Qty)+epsilon:
+		// Reducing or closing position
+		pos.RealizedPL += (price - pos.AvgCost) * -signed
+		pos.Qty += signed
+		if math.Abs(pos.Qty) < epsilon {
+			pos.Qty, pos.AvgCost = 0, 0
+		}
+	default:
+		// Flipping position to the other side
+		pos.RealizedPL += (price - pos.AvgCost) * pos.Qty
+		pos.Qty += signed
+		pos.AvgCost = price
+	}
+}
+
+// applyBar updates market prices with close prices of bar tradehook
+func (l *Ledger) applyBar(payload []byte) error {
+	var bar struct {
+		Bars map[string]map[string]map[string]interface{} `json:"bars"`
+	}
+	if err := json.Unmarshal(payload, &bar); err != nil {
+		return fmt.Errorf("invalid bar payload: %v", err)
+	}
+
+	keys := make([]string, 0, len(bar.Bars))
+	for key := range bar.Bars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, key := range keys {
+		for asset, ohlcv := range bar.Bars[key] {
+			if c, err := strconv.ParseFloat(fmt.Sprint(ohlcv["c"]), 64); err == nil {
+				l.prices[asset] = c
+			}
+		}
+	}
+	return nil
+}
+
+// Accounts returns ids of every account of the ledger
+func (l *Ledger) Accounts() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ids := make([]string, 0, len(l.accounts))
+	for id := range l.accounts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Cash returns cash balance of the account
+func (l *Ledger) Cash(accountID string) float64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if acc, ok := l.accounts[accountID]; ok {
+		return acc.cash
+	}
+	return 0
+}
+
+// Position returns position of the asset in the account
+func (l *Ledger) Position(accountID, asset string) (Position, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if acc, ok := l.accounts[accountID]; ok {
+		if pos, ok := acc.positions[asset]; ok {
+			return l.valued(pos), true
+		}
+	}
+	return Position{}, false
+}
+
+// Positions returns open positions of the account sorted by asset
+func (l *Ledger) Positions(accountID string) []Position {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	acc, ok := l.accounts[accountID]
+	if !ok {
+		return nil
+	}
+
+	positions := make([]Position, 0, len(acc.positions))
+	for _, pos := range acc.positions {
+		if pos.Qty != 0 {
+			positions = append(positions, l.valued(pos))
+		}
+	}
+	sort.Slice(positions, func(i, j int) bool {
+		return positions[i].Asset < positions[j].Asset
+	})
+	return positions
+}
+
+// Equity returns cash and market value of positions of the account
+func (l *Ledger) Equity(accountID string) float64 {
+	equity := l.Cash(accountID)
+	for _, pos := range l.Positions(accountID) {
+		equity += pos.MarketValue()
+	}
+	return equity
+}
+
+// valued returns copy of the position with last known market price
+func (l *Ledger) valued(pos *Position) Position {
+	p := *pos
+	p.MarketPrice = l.prices[p.Asset]
+	return p
+}
+
+// account returns account by id, creating it if necessary
+func (l *Ledger) account(accountID string) *account {
+	acc, ok := l.accounts[accountID]
+	if !ok {
+		acc = &account{positions: make(map[string]*Position)}
+		l.accounts[accountID] = acc
+	}
+	return acc
+}
@@ -0,0 +1,280 @@
+package portfolio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/tradologics/go-sdk/backtest"
+	"github.com/tradologics/go-sdk/net/http"
+	_http "net/http"
+	"testing"
+	"time"
+)
+
+// staticTransport answers every EROC request with the same response
+type staticTransport struct {
+	response string
+}
+
+func (s *staticTransport) SendJSON(src interface{}) error {
+	return nil
+}
+
+func (s *staticTransport) ReceiveJSON(dst interface{}) error {
+	return json.Unmarshal([]byte(s.response), dst)
+}
+
+func (s *staticTransport) Close() {}
+
+func fillPayload(orderID, side string, filledQty, avgPrice float64) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"order_id":       orderID,
+		"account_id":     "acc",
+		"asset":          "AAPL",
+		"side":           side,
+		"filled_qty":     filledQty,
+		"avg_fill_price": avgPrice,
+	})
+	return data
+}
+
+func TestLedgerAppliesFills(t *testing.T) {
+	ledger := NewLedger()
+	ledger.SetCash("acc", 10000)
+
+	// Partial fills carry cumulative quantity and average price
+	assert.NoError(t, ledger.Handle("order_partially_filled", fillPayload("1", "buy", 10, 100)))
+	assert.NoError(t, ledger.Handle("order_filled", fillPayload("1", "buy", 20, 101)))
+	assert.NoError(t, ledger.Handle("order_filled", fillPayload("1", "buy", 20, 101)))
+
+	pos, ok := ledger.Position("acc", "AAPL")
+	assert.True(t, ok)
+	assert.Equal(t, 20.0, pos.Qty)
+	assert.InDelta(t, 101, pos.AvgCost, 1e-9)
+	assert.InDelta(t, 10000-2020, ledger.Cash("acc"), 1e-9)
+
+	// Selling more than held flips the position
+	assert.NoError(t, ledger.Handle("order_filled", fillPayload("2", "sell", 30, 110)))
+	pos, _ = ledger.Position("acc", "AAPL")
+	assert.Equal(t, -10.0, pos.Qty)
+	assert.Equal(t, 110.0, pos.AvgCost)
+	assert.InDelta(t, 180, pos.RealizedPL, 1e-9)
+
+	bar, _ := json.Marshal(map[string]interface{}{
+		"bars": map[string]interface{}{"2021-01-04T21:00:00": map[string]interface{}{"AAPL": map[string]interface{}{"c": 100}}},
+	})
+	assert.NoError(t, ledger.Handle("bar", bar))
+	pos, _ = ledger.Position("acc", "AAPL")
+	assert.Equal(t, 100.0, pos.UnrealizedPL())
+	assert.InDelta(t, 10000-2020+3300-1000, ledger.Equity("acc"), 1e-9)
+
+	assert.NoError(t, ledger.Handle("order_filled", fillPayload("3", "buy", 10, 100)))
+	assert.Empty(t, ledger.Positions("acc"))
+	assert.Equal(t, []string{"acc"}, ledger.Accounts())
+
+	assert.Error(t, ledger.Handle("order_filled", []byte("{")))
+	assert.NoError(t, ledger.Handle("price", []byte("{")))
+}
+
+func TestLedgerChargesCommission(t *testing.T) {
+	ledger := NewLedger()
+	ledger.SetCash("acc", 10000)
+
+	// Fill tradehooks carry cumulative commission of the order
+	commission := func(filledQty, avgPrice, commission float64) []byte {
+		data, _ := json.Marshal(map[string]interface{}{"order_id": "1", "account_id": "acc", "asset": "AAPL",
+			"side": "buy", "filled_qty": filledQty, "avg_fill_price": avgPrice, "commission": commission})
+		return data
+	}
+	assert.NoError(t, ledger.Handle("order_partially_filled", commission(10, 100, 1)))
+	assert.NoError(t, ledger.Handle("order_filled", commission(20, 101, 2)))
+	assert.NoError(t, ledger.Handle("order_filled", commission(20, 101, 2)))
+
+	assert.InDelta(t, 10000-2020-2, ledger.Cash("acc"), 1e-9)
+	pos, _ := ledger.Position("acc", "AAPL")
+	assert.InDelta(t, 101, pos.AvgCost, 1e-9)
+}
+
+func TestLedgerAppliesSandboxOrders(t *testing.T) {
+	ledger := NewLedger()
+
+	order := func(status string, filledQty float64, avgPrice interface{}) []byte {
+		data, _ := json.Marshal(map[string]interface{}{
+			"order_id":       "1",
+			"account_id":     "acc",
+			"asset":          "AAPL",
+			"side":           "buy",
+			"qty":            20,
+			"filled_qty":     filledQty,
+			"avg_fill_price": avgPrice,
+			"status":         status,
+		})
+		return data
+	}
+
+	// Every order event arrives as "order" tradehook
+	assert.NoError(t, ledger.Handle("order", order("accepted", 0, nil)))
+	assert.Empty(t, ledger.Positions("acc"))
+
+	assert.NoError(t, ledger.Handle("order", order("partially_filled", 10, 100)))
+	assert.NoError(t, ledger.Handle("order", order("filled", 20, 101)))
+	pos, ok := ledger.Position("acc", "AAPL")
+	assert.True(t, ok)
+	assert.Equal(t, 20.0, pos.Qty)
+	assert.InDelta(t, 101, pos.AvgCost, 1e-9)
+}
+
+func TestLedgerReconcile(t *testing.T) {
+	ledger := NewLedger()
+	ledger.Fill("acc", "AAPL", "buy", 10, 100)
+	ledger.Fill("acc", "MSFT", "buy", 5, 200)
+
+	drifts := ledger.Reconcile("acc", []Position{
+		{Asset: "AAPL", Qty: 10, AvgCost: 100},
+		{Asset: "MSFT", Qty: 4, AvgCost: 200},
+		{Asset: "TSLA", Qty: 1, AvgCost: 700},
+	})
+	assert.Equal(t, []Drift{
+		{AccountID: "acc", Asset: "MSFT", LedgerQty: 5, BrokerQty: 4, LedgerAvgCost: 200, BrokerAvgCost: 200},
+		{AccountID: "acc", Asset: "TSLA", BrokerQty: 1, BrokerAvgCost: 700},
+	}, drifts)
+}
+
+func TestLedgerReconcileCostTolerance(t *testing.T) {
+	ledger := NewLedger()
+	ledger.Fill("acc", "AAPL", "buy", 10, 100)
+
+	// Broker rounding of the average price isn't a drift
+	broker := []Position{{Asset: "AAPL", Qty: 10, AvgCost: 100.005}}
+	assert.Empty(t, ledger.Reconcile("acc", broker))
+
+	ledger.SetCostTolerance(1e-6)
+	assert.Equal(t, []Drift{
+		{AccountID: "acc", Asset: "AAPL", LedgerQty: 10, BrokerQty: 10, LedgerAvgCost: 100, BrokerAvgCost: 100.005},
+	}, ledger.Reconcile("acc", broker))
+}
+
+func TestLedgerReconcileEvery(t *testing.T) {
+	ledger := NewLedger()
+	ledger.Fill("acc", "AAPL", "buy", 10, 100)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var fetches int
+	fetch := func(accountID string) ([]Position, error) {
+		fetches++
+		if fetches == 1 {
+			return nil, errors.New("unavailable")
+		}
+		return []Position{{Asset: "AAPL", Qty: 5, AvgCost: 100}}, nil
+	}
+
+	var errs []error
+	var drifts []Drift
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ledger.ReconcileEvery(ctx, time.Millisecond, fetch, func(d []Drift) {
+			drifts = d
+			cancel()
+		}, func(err error) {
+			errs = append(errs, err)
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ReconcileEvery didn't stop after the context was done")
+	}
+
+	// Fetch error is reported and reconciliation goes on at the next tick
+	assert.Equal(t, []error{errors.New("unavailable")}, errs)
+	assert.Equal(t, 2, fetches)
+	assert.Equal(t, []Drift{
+		{AccountID: "acc", Asset: "AAPL", LedgerQty: 10, BrokerQty: 5, LedgerAvgCost: 100, BrokerAvgCost: 100},
+	}, drifts)
+}
+
+func TestAPIPositions(t *testing.T) {
+	transport := &staticTransport{response: `{"status":200,"errors":[],"data":[
+		{"account_id":"acc","asset":"AAPL","qty":10,"avg_entry_price":100.5},
+		{"account_id":"other","asset":"MSFT","qty":5,"avg_entry_price":200},
+		{"asset":"TSLA","qty":1,"avg_entry_price":700}
+	]}`}
+	client := http.NewBacktestClient(backtest.NewBacktestWithTransport("2021-01-04 21:00:00.000000", "2021-01-07 21:00:00.000000", transport))
+
+	// Positions of other accounts are skipped, positions without account belong to every account
+	positions, err := APIPositions(client)("acc")
+	assert.NoError(t, err)
+	assert.Equal(t, []Position{
+		{AccountID: "acc", Asset: "AAPL", Qty: 10, AvgCost: 100.5},
+		{AccountID: "acc", Asset: "TSLA", Qty: 1, AvgCost: 700},
+	}, positions)
+
+	transport.response = `{"status":401,"errors":[{"id":"authentication_error","message":"invalid token"}],"data":null}`
+	_, err = APIPositions(client)("acc")
+	assert.EqualError(t, err, "positions request failed: invalid token")
+}
+
+func TestLedgerMatchesBacktestPositions(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2021, 1, d, 21, 0, 0, 0, time.UTC)
+	}
+	bars := map[string][]backtest.Bar{
+		"AAPL": {
+			{Datetime: day(4), Open: 100, High: 105, Low: 99, Close: 104, Volume: 1000},
+			{Datetime: day(5), Open: 104, High: 108, Low: 103, Close: 107, Volume: 1000},
+			{Datetime: day(6), Open: 107, High: 107, Low: 95, Close: 96, Volume: 1000},
+		},
+	}
+
+	bt, err := backtest.NewBacktestWithOptions(day(3), day(6), backtest.WithDataSource(backtest.BarsSource(bars),
+		backtest.WithCommission(backtest.FixedCommission{Amount: 1.5})))
+	assert.NoError(t, err)
+	client := http.NewBacktestClient(bt)
+
+	ledger := NewLedger()
+	ledger.SetCash(backtest.AccountID, backtest.DefaultCash)
+
+	var orders int
+	strategy := ledger.Wrap(func(tradehook string, payload []byte) {
+		if tradehook != "bar" || orders == 2 {
+			return
+		}
+		orders++
+
+		data, _ := json.Marshal(map[string]interface{}{"asset": "AAPL", "side": "buy", "type": "market", "qty": 10})
+		res, err := client.Post("/orders", "application/json", bytes.NewBuffer(data))
+		assert.NoError(t, err)
+		assert.Equal(t, _http.StatusCreated, res.StatusCode)
+	})
+
+	runner, err := backtest.NewRunner(bt, strategy, []string{"AAPL"}, "1day")
+	assert.NoError(t, err)
+	assert.NoError(t, runner.Run())
+
+	pos, ok := ledger.Position(backtest.AccountID, "AAPL")
+	assert.True(t, ok)
+	assert.Equal(t, 20.0, pos.Qty)
+	assert.Equal(t, 105.5, pos.AvgCost)
+
+	broker, err := APIPositions(client)(backtest.AccountID)
+	assert.NoError(t, err)
+	assert.Empty(t, ledger.Reconcile(backtest.AccountID, broker))
+
+	// Commission charged by the engine is charged by the ledger too
+	res, err := client.Get("/accounts")
+	assert.NoError(t, err)
+	var accounts struct {
+		Data []struct {
+			Cash float64 `json:"cash"`
+		} `json:"data"`
+	}
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&accounts))
+	assert.InDelta(t, backtest.DefaultCash-1040-1070-3, accounts.Data[0].Cash, 1e-9)
+	assert.InDelta(t, accounts.Data[0].Cash, ledger.Cash(backtest.AccountID), 1e-9)
+}
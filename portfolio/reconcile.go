@@ -0,0 +1,153 @@
+package portfolio
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/tradologics/go-sdk/backtest"
+	"github.com/tradologics/go-sdk/net/http"
+	"io/ioutil"
+	"math"
+	_http "net/http"
+	"sort"
+	"time"
+)
+
+// Drift is a mismatch between the ledger and the broker position of an asset
+type Drift struct {
+	AccountID     string  `json:"account_id"`
+	Asset         string  `json:"asset"`
+	LedgerQty     float64 `json:"ledger_qty"`
+	BrokerQty     float64 `json:"broker_qty"`
+	LedgerAvgCost float64 `json:"ledger_avg_cost"`
+	BrokerAvgCost float64 `json:"broker_avg_cost"`
+}
+
+// String returns human-readable description of the drift
+func (d Drift) String() string {
+	return fmt.Sprintf("%s %s: ledger qty %v, broker qty %v", d.AccountID, d.Asset, d.LedgerQty, d.BrokerQty)
+}
+
+// PositionsFetcher returns broker positions of the account
+type PositionsFetcher func(accountID string) ([]Position, error)
+
+// APIPositions is a PositionsFetcher which reads positions API using selected client,
+// positions of other accounts are skipped
+func APIPositions(client *http.Client) PositionsFetcher {
+	return func(accountID string) ([]Position, error) {
+		res, err := client.Get("/positions")
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		var data struct {
+			Errors []backtest.ErocError `json:"errors"`
+			Data   []struct {
+				AccountID string  `json:"account_id"`
+				Asset     string  `json:"asset"`
+				Qty       float64 `json:"qty"`
+				AvgPrice  float64 `json:"avg_entry_price"`
+			} `json:"data"`
+		}
+		if err = json.Unmarshal(body, &data); err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode != _http.StatusOK {
+			if len(data.Errors) > 0 {
+				return nil, fmt.Errorf("positions request failed: %s", data.Errors[0].Message)
+			}
+			return nil, fmt.Errorf("positions request failed: %s", res.Status)
+		}
+
+		positions := make([]Position, 0, len(data.Data))
+		for _, p := range data.Data {
+			if p.AccountID != "" && p.AccountID != accountID {
+				continue
+			}
+			positions = append(positions, Position{AccountID: accountID, Asset: p.Asset, Qty: p.Qty, AvgCost: p.AvgPrice})
+		}
+		return positions, nil
+	}
+}
+
+// Reconcile compares ledger positions of the account with broker positions and returns drifts
+// sorted by asset; average cost is compared only for held assets within the cost tolerance
+func (l *Ledger) Reconcile(accountID string, broker []Position) []Drift {
+	l.mu.Lock()
+	tolerance := l.costTolerance
+	l.mu.Unlock()
+
+	type pair struct {
+		ledger, broker Position
+	}
+
+	pairs := make(map[string]*pair)
+	for _, pos := range l.Positions(accountID) {
+		pairs[pos.Asset] = &pair{ledger: pos}
+	}
+	for _, pos := range broker {
+		if _, ok := pairs[pos.Asset]; !ok {
+			pairs[pos.Asset] = &pair{}
+		}
+		pairs[pos.Asset].broker = pos
+	}
+
+	var drifts []Drift
+	for asset, p := range pairs {
+		qtyDrift := math.Abs(p.ledger.Qty-p.broker.Qty) > epsilon
+		costDrift := p.ledger.Qty != 0 && math.Abs(p.ledger.AvgCost-p.broker.AvgCost) > tolerance*math.Max(1, p.broker.AvgCost)
+		if !qtyDrift && !costDrift {
+			continue
+		}
+
+		drifts = append(drifts, Drift{
+			AccountID:     accountID,
+			Asset:         asset,
+			LedgerQty:     p.ledger.Qty,
+			BrokerQty:     p.broker.Qty,
+			LedgerAvgCost: p.ledger.AvgCost,
+			BrokerAvgCost: p.broker.AvgCost,
+		})
+	}
+	sort.Slice(drifts, func(i, j int) bool {
+		return drifts[i].Asset < drifts[j].Asset
+	})
+	return drifts
+}
+
+// ReconcileEvery reconciles every ledger account with positions returned by fetcher at selected interval
+// until the context is done; drifts and fetch errors are reported to the callbacks
+func (l *Ledger) ReconcileEvery(ctx context.Context, interval time.Duration, fetch PositionsFetcher,
+	onDrift func([]Drift), onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for _, accountID := range l.Accounts() {
+			broker, err := fetch(accountID)
+			if err != nil {
+				if onError != nil {
+					onError(err)
+				}
+				continue
+			}
+
+			if drifts := l.Reconcile(accountID, broker); len(drifts) > 0 && onDrift != nil {
+				onDrift(drifts)
+			}
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// SignatureHeader holds hex-encoded HMAC-SHA256 of the timestamp, a dot and the request body
+	SignatureHeader = "TGX-SIGNATURE"
+
+	// TimestampHeader holds unix time in seconds the request was signed at
+	TimestampHeader = "TGX-TIMESTAMP"
+
+	// DefaultMaxSkew is a maximum difference between the request timestamp and the server clock
+	DefaultMaxSkew = 5 * time.Minute
+
+	// DefaultMaxBodySize is a maximum size of authenticated request body in bytes
+	DefaultMaxBodySize = 4 << 20
+)
+
+// errBodyTooLarge is returned for request bodies over the auth limit
+var errBodyTooLarge = errors.New("request body is too large")
+
+// Auth authenticates tradehook requests before they reach the strategy. Every non-empty check is applied
+type Auth struct {
+	// Secret shared with the sender, requests must be signed with it
+	Secret string
+
+	// MaxSkew of signed requests timestamps, DefaultMaxSkew is used by default
+	MaxSkew time.Duration
+
+	// Token expected in "Authorization: Bearer" header
+	Token string
+
+	// AllowedIPs are IP addresses or CIDR ranges requests are accepted from
+	AllowedIPs []string
+
+	// TrustForwardedFor takes client IP from X-Forwarded-For addresses appended by trusted proxies,
+	// enable it behind a proxy only. Leading addresses are set by the client and are ignored
+	TrustForwardedFor bool
+
+	// TrustedProxies is a number of proxies in front of the server appending to X-Forwarded-For,
+	// the client IP is the address appended by the outermost one; 1 is used by default
+	TrustedProxies int
+
+	// MaxBodySize of requests in bytes, bodies are read before the signature is verified;
+	// DefaultMaxBodySize is used by default
+	MaxBodySize int64
+
+	// Now returns current time, time.Now is used by default
+	Now func() time.Time
+
+	mu       sync.Mutex
+	networks []*net.IPNet
+	parsed   bool
+	seen     map[string]time.Time
+}
+
+// Sign returns signature of the body signed at selected time, which is sent in SignatureHeader
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	return sign(secret, strconv.FormatInt(timestamp.Unix(), 10), body)
+}
+
+// sign returns hex-encoded HMAC-SHA256 of the timestamp and body
+func sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Middleware returns handler which answers unauthenticated requests with 401, too large ones with 413,
+// and passes the rest to next. Signatures of requests next doesn't answer with success are forgotten,
+// so their redeliveries are accepted
+func (a *Auth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.serve(w, r, next.ServeHTTP, func(reqErr *RequestError) {
+			writeError(w, r, reqErr)
+		})
+	})
+}
+
+// serve authenticates the request with limited body and calls next, failed checks are passed to reject
+func (a *Auth) serve(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, reject func(reqErr *RequestError)) {
+	maxBodySize := a.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+
+	if err := a.Authenticate(r); err != nil {
+		if err == errBodyTooLarge {
+			reject(&RequestError{Status: http.StatusRequestEntityTooLarge, ID: "body_too_large", Message: err.Error()})
+			return
+		}
+		reject(&RequestError{Status: http.StatusUnauthorized, ID: "unauthorized", Message: err.Error()})
+		return
+	}
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next(rec, r)
+	if rec.status < 200 || rec.status >= 300 {
+		a.Forget(r)
+	}
+}
+
+// Forget removes signature of the request from received ones, so its redelivery is accepted.
+// Middleware forgets requests which weren't handled successfully, e.g. answered with 500 or 503
+func (a *Auth) Forget(r *http.Request) {
+	signature := r.Header.Get(SignatureHeader)
+	if a.Secret == "" || signature == "" {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	delete(a.seen, signature)
+}
+
+// Authenticate returns an error if the request fails any check; the body is read for signature
+// verification and replaced, so it can be read again
+func (a *Auth) Authenticate(r *http.Request) error {
+	if err := a.checkIP(r); err != nil {
+		return err
+	}
+	if err := a.checkToken(r); err != nil {
+		return err
+	}
+	return a.checkSignature(r)
+}
+
+// checkIP returns an error if the client IP isn't allowed
+func (a *Auth) checkIP(r *http.Request) error {
+	if len(a.AllowedIPs) == 0 {
+		return nil
+	}
+
+	networks, err := a.allowedNetworks()
+	if err != nil {
+		return err
+	}
+
+	addr := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	if a.TrustForwardedFor {
+		if forwarded, err := a.forwardedFor(r); err != nil {
+			return err
+		} else if forwarded != "" {
+			addr = forwarded
+		}
+	}
+
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return fmt.Errorf("invalid client IP %q", addr)
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("IP %s is not allowed", ip)
+}
+
+// forwardedFor returns X-Forwarded-For address appended by the outermost trusted proxy,
+// or empty string if the header isn't set
+func (a *Auth) forwardedFor(r *http.Request) (string, error) {
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(header, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	if len(hops) == 0 {
+		return "", nil
+	}
+
+	proxies := a.TrustedProxies
+	if proxies <= 0 {
+		proxies = 1
+	}
+	if len(hops) < proxies {
+		return "", fmt.Errorf("X-Forwarded-For has %d addresses, %d trusted proxies expected", len(hops), proxies)
+	}
+	return hops[len(hops)-proxies], nil
+}
+
+// allowedNetworks parses allowed IPs once they all parse
+func (a *Auth) allowedNetworks() ([]*net.IPNet, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.parsed {
+		return a.networks, nil
+	}
+
+	networks := make([]*net.IPNet, 0, len(a.AllowedIPs))
+	for _, value := range a.AllowedIPs {
+		cidr := value
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed IP %q", value)
+		}
+		networks = append(networks, network)
+	}
+	a.networks, a.parsed = networks, true
+
+	return a.networks, nil
+}
+
+// checkToken returns an error if the Authorization header isn't the bearer token
+func (a *Auth) checkToken(r *http.Request) error {
+	if a.Token == "" {
+		return nil
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return errors.New("invalid bearer token")
+	}
+	token := header[len("Bearer "):]
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) != 1 {
+		return errors.New("invalid bearer token")
+	}
+	return nil
+}
+
+// checkSignature returns an error if the request isn't signed with the secret, is too old
+// or was already received
+func (a *Auth) checkSignature(r *http.Request) error {
+	if a.Secret == "" {
+		return nil
+	}
+
+	timestamp := r.Header.Get(TimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid %s header", TimestampHeader)
+	}
+
+	now := time.Now()
+	if a.Now != nil {
+		now = a.Now()
+	}
+	maxSkew := a.MaxSkew
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	signedAt := time.Unix(unix, 0)
+	if signedAt.Before(now.Add(-maxSkew)) || signedAt.After(now.Add(maxSkew)) {
+		return errors.New("request timestamp is out of the allowed window")
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		// http.MaxBytesReader error has no exported type before Go 1.19
+		if err.Error() == "http: request body too large" {
+			return errBodyTooLarge
+		}
+		return err
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	signature := r.Header.Get(SignatureHeader)
+	if !hmac.Equal([]byte(signature), []byte(sign(a.Secret, timestamp, body))) {
+		return errors.New("invalid signature")
+	}
+
+	return a.remember(signature, signedAt.Add(maxSkew), now)
+}
+
+// remember returns an error if the signature was already received, signatures are kept
+// until their timestamp leaves the allowed window
+func (a *Auth) remember(signature string, expires, now time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.seen == nil {
+		a.seen = make(map[string]time.Time)
+	}
+	for s, exp := range a.seen {
+		if exp.Before(now) {
+			delete(a.seen, s)
+		}
+	}
+
+	if _, ok := a.seen[signature]; ok {
+		return errors.New("request was already received")
+	}
+	a.seen[signature] = expires
+	return nil
+}
+
+// statusRecorder keeps status code of the response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status code and writes it
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signedRequest(secret string, timestamp time.Time, body []byte) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+	req.Header.Set(SignatureHeader, Sign(secret, timestamp, body))
+	return req
+}
+
+func TestAuthVerifiesSignature(t *testing.T) {
+	now := time.Date(2021, 1, 4, 21, 0, 0, 0, time.UTC)
+	auth := &Auth{Secret: "secret", Now: func() time.Time {
+		return now
+	}}
+
+	var calls int
+	handler := auth.Middleware(router(func(tradehook string, payload []byte) {
+		calls++
+		assert.Equal(t, "bar", tradehook)
+	}, "/"))
+
+	body := []byte(`{"event":"bar","data":{}}`)
+	for _, c := range []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"valid", signedRequest("secret", now, body), http.StatusOK},
+		{"replayed", signedRequest("secret", now, body), http.StatusUnauthorized},
+		{"wrong secret", signedRequest("foo", now.Add(time.Second), body), http.StatusUnauthorized},
+		{"expired", signedRequest("secret", now.Add(-10*time.Minute), body), http.StatusUnauthorized},
+		{"unsigned", httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body)), http.StatusUnauthorized},
+	} {
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, c.req)
+		assert.Equal(t, c.status, res.Code, c.name)
+	}
+
+	// Tampered body
+	req := signedRequest("secret", now.Add(2*time.Second), body)
+	req.Body = http.NoBody
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	assert.Equal(t, 1, calls)
+}
+
+func TestAuthAcceptsRedeliveryOfFailedRequests(t *testing.T) {
+	now := time.Date(2021, 1, 4, 21, 0, 0, 0, time.UTC)
+	auth := &Auth{Secret: "secret", MaxBodySize: 64, Now: func() time.Time {
+		return now
+	}}
+
+	var calls int
+	handler := auth.Middleware(router(func(tradehook string, payload []byte) {
+		calls++
+		if calls == 1 {
+			panic("boom")
+		}
+	}, "/"))
+
+	// Redelivery of a request failed with 500 isn't a replay
+	body := []byte(`{"event":"bar","data":{}}`)
+	for _, status := range []int{http.StatusInternalServerError, http.StatusOK, http.StatusUnauthorized} {
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, signedRequest("secret", now, body))
+		assert.Equal(t, status, res.Code)
+	}
+	assert.Equal(t, 2, calls)
+
+	// Body is limited before the signature is verified
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, signedRequest("secret", now, bytes.Repeat([]byte(" "), 65)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.Code)
+	assert.Equal(t, 2, calls)
+}
+
+func TestAuthChecksTokenAndIP(t *testing.T) {
+	auth := &Auth{Token: "token", AllowedIPs: []string{"10.0.0.0/8", "192.168.1.1"}}
+
+	for _, c := range []struct {
+		remoteAddr string
+		token      string
+		err        bool
+	}{
+		{"10.1.2.3:1234", "token", false},
+		{"192.168.1.1:1234", "token", false},
+		{"192.168.1.2:1234", "token", true},
+		{"10.1.2.3:1234", "foo", true},
+		{"10.1.2.3:1234", "", true},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = c.remoteAddr
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+
+		err := auth.Authenticate(req)
+		if c.err {
+			assert.Error(t, err, c.remoteAddr, c.token)
+		} else {
+			assert.NoError(t, err, c.remoteAddr, c.token)
+		}
+	}
+
+	// Forwarded address is used only when trusted
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "127.0.0.1:1234"
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	assert.Error(t, auth.Authenticate(req))
+
+	auth.TrustForwardedFor = true
+	assert.NoError(t, auth.Authenticate(req))
+
+	// Leading address is set by the client, the proxy appends the real one
+	req.Header.Set("X-Forwarded-For", "10.9.9.9, 203.0.113.7")
+	assert.Error(t, auth.Authenticate(req))
+
+	// Client address is appended by the outermost of trusted proxies
+	auth.TrustedProxies = 2
+	req.Header.Set("X-Forwarded-For", "10.9.9.9, 203.0.113.7, 10.0.0.2")
+	assert.Error(t, auth.Authenticate(req))
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1, 192.168.1.1")
+	assert.NoError(t, auth.Authenticate(req))
+	req.Header.Set("X-Forwarded-For", "10.0.0.1")
+	assert.Error(t, auth.Authenticate(req))
+
+	// Token without the scheme isn't accepted
+	req = httptest.NewRequest(http.MethodPost, "/", nil)
+	req.RemoteAddr = "10.1.2.3:1234"
+	req.Header.Set("Authorization", "token")
+	assert.Error(t, auth.Authenticate(req))
+}
+
+func TestAuthRejectsInvalidAllowedIP(t *testing.T) {
+	auth := &Auth{AllowedIPs: []string{"10.0.0.0/8", "bogus"}}
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/", nil)
+		req.RemoteAddr = "10.1.2.3:1234"
+		assert.EqualError(t, auth.Authenticate(req), `invalid allowed IP "bogus"`)
+	}
+	assert.Empty(t, auth.networks)
+}
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// EventIDHeader holds unique tradehook id, which is kept by redeliveries
+	EventIDHeader = "TGX-EVENT-ID"
+
+	DefaultDedupWindow   = 24 * time.Hour
+	DefaultDedupCapacity = 100000
+
+	// fileStoreCompactInterval is a period FileStore drops expired ids and rewrites its file after
+	fileStoreCompactInterval = time.Hour
+)
+
+// DedupStore records ids of handled tradehooks
+type DedupStore interface {
+	// Add records the id until it expires and returns false if it's already recorded
+	Add(id string, expires time.Time) (bool, error)
+
+	// Remove forgets the id, so the tradehook is handled again when it's redelivered
+	Remove(id string) error
+}
+
+// DedupOptions configure tradehook deduplication of an endpoint
+type DedupOptions struct {
+	// Store of handled tradehook ids, MemoryStore of DefaultDedupCapacity ids is used by default
+	Store DedupStore
+
+	// Window is a period a tradehook id is remembered for, DefaultDedupWindow is used by default
+	Window time.Duration
+
+	// ID returns tradehook id, EventID is used by default
+	ID func(r *http.Request, body []byte) string
+}
+
+// WithDedup answers redelivered tradehooks of the endpoint with 200 without calling the strategy.
+// Ids of tradehooks the strategy failed to handle are forgotten, so their redeliveries are handled.
+// Redeliveries received while the tradehook is still handled are answered with 409 and Retry-After,
+// so the platform delivers them again once it's known whether the handling succeeded
+func WithDedup(opts DedupOptions) EndpointOption {
+	return func(e *endpoint) {
+		if opts.Store == nil {
+			opts.Store = NewMemoryStore(DefaultDedupCapacity)
+		}
+		if opts.Window <= 0 {
+			opts.Window = DefaultDedupWindow
+		}
+		if opts.ID == nil {
+			opts.ID = EventID
+		}
+		e.dedup = &opts
+	}
+}
+
+// EventID returns id of the tradehook from EventIDHeader, "id" field of the request body
+// or SHA-256 of the body if neither is set
+func EventID(r *http.Request, body []byte) string {
+	if id := r.Header.Get(EventIDHeader); id != "" {
+		return id
+	}
+
+	var data struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &data); err == nil && data.ID != "" {
+		return data.ID
+	}
+
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// begin marks the tradehook id in progress and returns false if it's already handled by another request
+func (e *endpoint) begin(eventID string) bool {
+	e.inflightMu.Lock()
+	defer e.inflightMu.Unlock()
+
+	if _, ok := e.inflight[eventID]; ok {
+		return false
+	}
+	if e.inflight == nil {
+		e.inflight = make(map[string]struct{})
+	}
+	e.inflight[eventID] = struct{}{}
+	return true
+}
+
+// finish marks the tradehook id handled
+func (e *endpoint) finish(eventID string) {
+	e.inflightMu.Lock()
+	defer e.inflightMu.Unlock()
+
+	delete(e.inflight, eventID)
+}
+
+// MemoryStore is a DedupStore which keeps up to capacity least recently seen ids in memory,
+// an id is seen when it's added or its duplicate is found
+type MemoryStore struct {
+	mu       sync.Mutex
+	capacity int
+	ids      map[string]*list.Element
+	order    *list.List
+	now      func() time.Time
+}
+
+// memoryEntry is an id of MemoryStore
+type memoryEntry struct {
+	id      string
+	expires time.Time
+}
+
+// NewMemoryStore create new MemoryStore of selected capacity
+func NewMemoryStore(capacity int) *MemoryStore {
+	if capacity <= 0 {
+		capacity = DefaultDedupCapacity
+	}
+	return &MemoryStore{
+		capacity: capacity,
+		ids:      make(map[string]*list.Element),
+		order:    list.New(),
+		now:      time.Now,
+	}
+}
+
+// Add records the id and evicts the least recently seen one if the store is full
+func (s *MemoryStore) Add(id string, expires time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.ids[id]; ok {
+		if el.Value.(*memoryEntry).expires.After(s.now()) {
+			s.order.MoveToBack(el)
+			return false, nil
+		}
+		s.order.Remove(el)
+		delete(s.ids, id)
+	}
+
+	s.ids[id] = s.order.PushBack(&memoryEntry{id: id, expires: expires})
+	for s.order.Len() > s.capacity {
+		oldest := s.order.Front()
+		s.order.Remove(oldest)
+		delete(s.ids, oldest.Value.(*memoryEntry).id)
+	}
+	return true, nil
+}
+
+// Remove forgets the id
+func (s *MemoryStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.ids[id]; ok {
+		s.order.Remove(el)
+		delete(s.ids, id)
+	}
+	return nil
+}
+
+// FileStore is a DedupStore which appends ids to a file, so they survive restarts.
+// Expired and removed ids are dropped from memory and the file when it's opened and hourly after that
+type FileStore struct {
+	mu          sync.Mutex
+	path        string
+	file        *os.File
+	ids         map[string]time.Time
+	now         func() time.Time
+	compactedAt time.Time
+}
+
+// NewFileStore opens FileStore at selected path, creating the file if necessary
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, ids: make(map[string]time.Time), now: time.Now}
+
+	if err := s.load(path); err != nil {
+		return nil, err
+	}
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Add records the id and appends it to the file, which is compacted first if it's due
+func (s *FileStore) Add(id string, expires time.Time) (bool, error) {
+	if strings.ContainsAny(id, "\t\n") {
+		return false, fmt.Errorf("invalid tradehook id %q", id)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.now().Sub(s.compactedAt) >= fileStoreCompactInterval {
+		if err := s.compact(); err != nil {
+			return false, err
+		}
+	}
+
+	if exp, ok := s.ids[id]; ok && exp.After(s.now()) {
+		return false, nil
+	}
+
+	if _, err := fmt.Fprintf(s.file, "+\t%s\t%d\n", id, expires.Unix()); err != nil {
+		return false, err
+	}
+	s.ids[id] = expires
+	return true, nil
+}
+
+// Remove forgets the id and appends the removal to the file
+func (s *FileStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.ids[id]; !ok {
+		return nil
+	}
+
+	if _, err := fmt.Fprintf(s.file, "-\t%s\n", id); err != nil {
+		return err
+	}
+	delete(s.ids, id)
+	return nil
+}
+
+// Close closes the file
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
+
+// load reads ids of the file, a truncated last line of an interrupted write is ignored
+func (s *FileStore) load(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		switch {
+		case len(fields) == 3 && fields[0] == "+":
+			unix, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				continue
+			}
+			s.ids[fields[1]] = time.Unix(unix, 0)
+		case len(fields) == 2 && fields[0] == "-":
+			delete(s.ids, fields[1])
+		}
+	}
+	return scanner.Err()
+}
+
+// compact drops expired ids, rewrites the file with the rest and reopens it for appending
+func (s *FileStore) compact() error {
+	tmp := s.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(file)
+	now := s.now()
+	for id, expires := range s.ids {
+		if !expires.After(now) {
+			delete(s.ids, id)
+			continue
+		}
+		fmt.Fprintf(writer, "+\t%s\t%d\n", id, expires.Unix())
+	}
+	if err = writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	file, err = os.OpenFile(s.path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, s.compactedAt = file, now
+	return nil
+}
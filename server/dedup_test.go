@@ -0,0 +1,198 @@
+package server
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDedupDeliversTradehookOnce(t *testing.T) {
+	var calls int
+	fail := true
+
+	s := NewServer(nil, "", "127.0.0.1", 0)
+	assert.NoError(t, s.Handle("/", func(tradehook string, payload []byte) {
+		calls++
+		if fail {
+			fail = false
+			panic("boom")
+		}
+	}, WithDedup(DedupOptions{}), WithErrorHandler(func(r *http.Request, err *RequestError) {})))
+
+	post := func(id, body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		if id != "" {
+			req.Header.Set(EventIDHeader, id)
+		}
+		res := httptest.NewRecorder()
+		s.Handler().ServeHTTP(res, req)
+		return res.Code
+	}
+
+	// Failed tradehook is handled again when it's redelivered
+	assert.Equal(t, http.StatusInternalServerError, post("1", `{"event":"order_filled","data":{}}`))
+	assert.Equal(t, http.StatusOK, post("1", `{"event":"order_filled","data":{}}`))
+	assert.Equal(t, http.StatusOK, post("1", `{"event":"order_filled","data":{}}`))
+	assert.Equal(t, 2, calls)
+
+	// Id is taken from the body or its hash without the header
+	assert.Equal(t, http.StatusOK, post("", `{"id":"2","event":"order_filled","data":{}}`))
+	assert.Equal(t, http.StatusOK, post("", `{"id":"2","event":"order_filled","data":{"foo":1}}`))
+	assert.Equal(t, http.StatusOK, post("", `{"event":"order_filled","data":{"foo":1}}`))
+	assert.Equal(t, http.StatusOK, post("", `{"event":"order_filled","data":{"foo":1}}`))
+	assert.Equal(t, 4, calls)
+}
+
+func TestDedupRejectsRedeliveryInProgress(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var calls int
+
+	s := NewServer(nil, "", "127.0.0.1", 0)
+	assert.NoError(t, s.Handle("/", func(tradehook string, payload []byte) {
+		calls++
+		if calls == 1 {
+			close(started)
+			<-release
+			panic("boom")
+		}
+	}, WithDedup(DedupOptions{}), WithErrorHandler(func(r *http.Request, err *RequestError) {})))
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"event":"order_filled","data":{}}`))
+		req.Header.Set(EventIDHeader, "1")
+		res := httptest.NewRecorder()
+		s.Handler().ServeHTTP(res, req)
+		return res
+	}
+
+	first := make(chan int, 1)
+	go func() {
+		first <- post().Code
+	}()
+	<-started
+
+	// Redelivery isn't acknowledged until the first delivery is handled
+	res := post()
+	assert.Equal(t, http.StatusConflict, res.Code)
+	assert.Equal(t, "1", res.Header().Get("Retry-After"))
+
+	close(release)
+	assert.Equal(t, http.StatusInternalServerError, <-first)
+	assert.Equal(t, http.StatusOK, post().Code)
+	assert.Equal(t, 2, calls)
+}
+
+func TestMemoryStoreEvictsLeastRecentlySeenIDs(t *testing.T) {
+	now := time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore(2)
+	store.now = func() time.Time {
+		return now
+	}
+
+	for _, id := range []string{"a", "b", "a", "c"} {
+		_, err := store.Add(id, now.Add(time.Hour))
+		assert.NoError(t, err)
+	}
+
+	// Duplicate "a" is seen after "b", so "b" is evicted
+	added, _ := store.Add("a", now.Add(time.Hour))
+	assert.False(t, added)
+	added, _ = store.Add("b", now.Add(time.Hour))
+	assert.True(t, added)
+}
+
+func TestMemoryStoreEvictsOldestIDs(t *testing.T) {
+	now := time.Date(2021, 1, 4, 0, 0, 0, 0, time.UTC)
+	store := NewMemoryStore(2)
+	store.now = func() time.Time {
+		return now
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		added, err := store.Add(id, now.Add(time.Hour))
+		assert.NoError(t, err)
+		assert.True(t, added)
+	}
+
+	added, _ := store.Add("c", now.Add(time.Hour))
+	assert.False(t, added)
+	added, _ = store.Add("a", now.Add(time.Hour))
+	assert.True(t, added)
+
+	// Expired id is added again
+	now = now.Add(2 * time.Hour)
+	added, _ = store.Add("a", now.Add(time.Hour))
+	assert.True(t, added)
+}
+
+func TestFileStoreSurvivesRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.log")
+	now := time.Now()
+
+	store, err := NewFileStore(path)
+	assert.NoError(t, err)
+	for _, id := range []string{"a", "b", "c"} {
+		_, err = store.Add(id, now.Add(time.Hour))
+		assert.NoError(t, err)
+	}
+	_, err = store.Add("expired", now.Add(-time.Hour))
+	assert.NoError(t, err)
+	assert.NoError(t, store.Remove("b"))
+	assert.NoError(t, store.Close())
+
+	store, err = NewFileStore(path)
+	assert.NoError(t, err)
+	defer store.Close()
+
+	for id, expected := range map[string]bool{"a": false, "b": true, "c": false, "expired": true} {
+		added, err := store.Add(id, now.Add(time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, added, id)
+	}
+
+	_, err = store.Add("foo\nbar", now)
+	assert.Error(t, err)
+}
+
+func TestFileStoreDropsExpiredIDsWhileRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.log")
+	now := time.Now()
+
+	store, err := NewFileStore(path)
+	assert.NoError(t, err)
+	defer store.Close()
+	store.now = func() time.Time {
+		return now
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		_, err = store.Add(id, now.Add(time.Minute))
+		assert.NoError(t, err)
+	}
+	_, err = store.Add("d", now.Add(3*time.Hour))
+	assert.NoError(t, err)
+	assert.NoError(t, store.Remove("c"))
+
+	// Expired ids are dropped once compaction is due
+	now = now.Add(fileStoreCompactInterval + time.Minute)
+	_, err = store.Add("e", now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, store.ids, 2)
+	assert.Contains(t, store.ids, "d")
+	assert.Contains(t, store.ids, "e")
+
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(data), "\n"))
+	assert.NotContains(t, string(data), "a\t")
+
+	added, err := store.Add("d", now.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.False(t, added)
+}
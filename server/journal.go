@@ -0,0 +1,264 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxAttempts is a number of times a journaled tradehook is handled before it's dead-lettered
+	DefaultMaxAttempts = 3
+
+	// DefaultRetryBackoff is a delay before the second attempt of a failed journaled tradehook
+	DefaultRetryBackoff = 100 * time.Millisecond
+)
+
+// JournalOptions configure write-ahead log of an endpoint
+type JournalOptions struct {
+	// Dir keeps unfinished tradehooks in "pending" and repeatedly failed ones in "dead" subdirectory
+	Dir string
+
+	// MaxAttempts is a number of times a tradehook is handled before it's moved to the dead-letter directory,
+	// DefaultMaxAttempts is used by default
+	MaxAttempts int
+
+	// RetryBackoff is a delay before the second attempt, it doubles for every next one;
+	// DefaultRetryBackoff is used by default
+	RetryBackoff time.Duration
+}
+
+// WithJournal persists each tradehook of the endpoint before it's acknowledged and removes it after
+// the strategy handles it. Failed tradehooks are retried with backoff and moved to the dead-letter directory
+// after MaxAttempts. Unfinished ones are replayed one by one in the order they were received when the server
+// starts, before it listens, so the server isn't ready until the replay including retries is over.
+// Tradehooks are acknowledged with 200 once persisted, as the journal takes care of their delivery, and
+// handled in the background: by a single worker in the order they were received, or by WithAsync workers.
+// Ids of WithDedup are journaled with their tradehooks and recorded again on replay.
+// Errors of replayed tradehooks are reported with nil request
+func WithJournal(opts JournalOptions) EndpointOption {
+	return func(e *endpoint) {
+		if opts.MaxAttempts <= 0 {
+			opts.MaxAttempts = DefaultMaxAttempts
+		}
+		if opts.RetryBackoff <= 0 {
+			opts.RetryBackoff = DefaultRetryBackoff
+		}
+		e.journalOpts = &opts
+	}
+}
+
+// journalEntry is a persisted tradehook
+type journalEntry struct {
+	Tradehook  string          `json:"event"`
+	EventID    string          `json:"event_id,omitempty"`
+	Data       json.RawMessage `json:"data"`
+	Attempts   int             `json:"attempts"`
+	ReceivedAt time.Time       `json:"received_at"`
+
+	name string
+}
+
+// journal is a directory of persisted tradehooks, a file per tradehook
+type journal struct {
+	mu          sync.Mutex
+	pendingDir  string
+	deadDir     string
+	maxAttempts int
+	backoff     time.Duration
+	seq         uint64
+}
+
+// newJournal create new journal in the directory
+func newJournal(opts JournalOptions) (*journal, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("journal directory is required")
+	}
+
+	j := &journal{
+		pendingDir:  filepath.Join(opts.Dir, "pending"),
+		deadDir:     filepath.Join(opts.Dir, "dead"),
+		maxAttempts: opts.MaxAttempts,
+		backoff:     opts.RetryBackoff,
+	}
+	for _, dir := range []string{j.pendingDir, j.deadDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	return j, nil
+}
+
+// append persists new tradehook with its dedup id; file names keep the order tradehooks were received in
+func (j *journal) append(tradehook, eventID string, data []byte) (*journalEntry, error) {
+	j.mu.Lock()
+	j.seq++
+	now := time.Now()
+	name := fmt.Sprintf("%020d-%06d.json", now.UnixNano(), j.seq%1000000)
+	j.mu.Unlock()
+
+	entry := &journalEntry{Tradehook: tradehook, EventID: eventID, Data: data, ReceivedAt: now.UTC(), name: name}
+	if err := j.save(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// save writes the entry atomically and syncs it to disk
+func (j *journal) save(entry *journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(j.pendingDir, entry.name)
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(j.pendingDir)
+}
+
+// done removes handled entry
+func (j *journal) done(entry *journalEntry) error {
+	return os.Remove(filepath.Join(j.pendingDir, entry.name))
+}
+
+// deadLetter moves failed entry to the dead-letter directory
+func (j *journal) deadLetter(entry *journalEntry) error {
+	return os.Rename(filepath.Join(j.pendingDir, entry.name), filepath.Join(j.deadDir, entry.name))
+}
+
+// pending returns unfinished entries in the order they were received
+func (j *journal) pending() ([]*journalEntry, error) {
+	files, err := ioutil.ReadDir(j.pendingDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*journalEntry
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(j.pendingDir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		entry := &journalEntry{name: file.Name()}
+		if err = json.Unmarshal(data, entry); err != nil {
+			// Unreadable entry can't be handled, keep it for investigation
+			if err = j.deadLetter(entry); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].name < entries[b].name
+	})
+	return entries, nil
+}
+
+// syncDir syncs directory entries, so renames survive a crash
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	// Some platforms can't sync directories, the rename is still done
+	_ = dir.Sync()
+	return nil
+}
+
+// processEntry handles journaled tradehook until it succeeds or runs out of attempts, waiting the doubling
+// backoff between attempts. Attempts are persisted before the strategy is called, so tradehooks crashing
+// the process are dead-lettered too
+func (e *endpoint) processEntry(r *http.Request, entry *journalEntry) {
+	for retry := 0; ; retry++ {
+		if entry.Attempts >= e.journal.maxAttempts {
+			if err := e.journal.deadLetter(entry); err != nil {
+				e.reportError(r, &RequestError{Status: http.StatusInternalServerError, ID: "journal_error",
+					Message: err.Error(), Tradehook: entry.Tradehook, Body: entry.Data})
+			}
+			return
+		}
+
+		if retry > 0 {
+			time.Sleep(e.journal.backoff << (retry - 1))
+		}
+
+		entry.Attempts++
+		if err := e.journal.save(entry); err != nil {
+			e.reportError(r, &RequestError{Status: http.StatusInternalServerError, ID: "journal_error",
+				Message: err.Error(), Tradehook: entry.Tradehook, Body: entry.Data})
+			return
+		}
+
+		reqErr := e.callStrategy(r, entry.Tradehook, entry.Data)
+		if reqErr == nil {
+			if err := e.journal.done(entry); err != nil {
+				e.reportError(r, &RequestError{Status: http.StatusInternalServerError, ID: "journal_error",
+					Message: err.Error(), Tradehook: entry.Tradehook, Body: entry.Data})
+			}
+			return
+		}
+
+		reqErr.Body = entry.Data
+		e.reportError(r, reqErr)
+	}
+}
+
+// replay handles unfinished tradehooks of the journal sequentially in the order they were received.
+// Their dedup ids are recorded first, as the process could stop before it recorded them on receipt
+func (e *endpoint) replay() error {
+	entries, err := e.journal.pending()
+	if err != nil {
+		return err
+	}
+
+	if e.dedup != nil {
+		for _, entry := range entries {
+			if entry.EventID == "" {
+				continue
+			}
+			if _, err = e.dedup.Store.Add(entry.EventID, entry.ReceivedAt.Add(e.dedup.Window)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, entry := range entries {
+		e.processEntry(nil, entry)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJournalDeadLettersFailedEvents(t *testing.T) {
+	dir := t.TempDir()
+	calls := map[string]int{}
+
+	s := NewServer(nil, "", "127.0.0.1", 0)
+	assert.NoError(t, s.Handle("/", func(tradehook string, payload []byte) {
+		calls[tradehook]++
+		if tradehook == "order_rejected" {
+			panic("boom")
+		}
+	}, WithJournal(JournalOptions{Dir: dir, MaxAttempts: 2}), WithErrorHandler(func(r *http.Request, err *RequestError) {})))
+
+	post := func(body string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body))
+		res := httptest.NewRecorder()
+		s.Handler().ServeHTTP(res, req)
+		return res.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post(`{"event":"order_filled","data":{}}`))
+	assert.Equal(t, http.StatusOK, post(`{"event":"order_rejected","data":{"id":"1"}}`))
+
+	// Shutdown waits until acknowledged tradehooks are handled
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, map[string]int{"order_filled": 1, "order_rejected": 2}, calls)
+
+	pending, _ := ioutil.ReadDir(filepath.Join(dir, "pending"))
+	assert.Empty(t, pending)
+
+	dead, _ := ioutil.ReadDir(filepath.Join(dir, "dead"))
+	assert.Len(t, dead, 1)
+	data, err := ioutil.ReadFile(filepath.Join(dir, "dead", dead[0].Name()))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"event":"order_rejected"`)
+	assert.Contains(t, string(data), `"attempts":2`)
+}
+
+func TestJournalRetriesWithBackoff(t *testing.T) {
+	dir := t.TempDir()
+
+	var mu sync.Mutex
+	var attempts []time.Time
+	s := NewServer(nil, "", "127.0.0.1", 0)
+	assert.NoError(t, s.Handle("/", func(tradehook string, payload []byte) {
+		mu.Lock()
+		attempts = append(attempts, time.Now())
+		n := len(attempts)
+		mu.Unlock()
+		if n < 3 {
+			panic("boom")
+		}
+	}, WithJournal(JournalOptions{Dir: dir, RetryBackoff: 20 * time.Millisecond}), WithErrorHandler(func(r *http.Request, err *RequestError) {})))
+
+	// The request is acknowledged once persisted, before retries are over
+	res := httptest.NewRecorder()
+	s.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"event":"bar","data":{}}`)))
+	assert.Equal(t, http.StatusOK, res.Code)
+	mu.Lock()
+	assert.Less(t, len(attempts), 3)
+	mu.Unlock()
+
+	assert.NoError(t, s.Shutdown(context.Background()))
+
+	// Backoff doubles between attempts
+	assert.Len(t, attempts, 3)
+	assert.GreaterOrEqual(t, int64(attempts[1].Sub(attempts[0])), int64(20*time.Millisecond))
+	assert.GreaterOrEqual(t, int64(attempts[2].Sub(attempts[1])), int64(40*time.Millisecond))
+
+	pending, _ := ioutil.ReadDir(filepath.Join(dir, "pending"))
+	assert.Empty(t, pending)
+	dead, _ := ioutil.ReadDir(filepath.Join(dir, "dead"))
+	assert.Empty(t, dead)
+}
+
+func TestJournalReplaysUnfinishedEvents(t *testing.T) {
+	dir := t.TempDir()
+
+	// Tradehooks left by a crashed process, the last one crashed it on every attempt
+	j, err := newJournal(JournalOptions{Dir: dir, MaxAttempts: 3})
+	assert.NoError(t, err)
+	for _, tradehook := range []string{"order_received", "order_filled"} {
+		_, err = j.append(tradehook, "", []byte(`{}`))
+		assert.NoError(t, err)
+	}
+	crashed, err := j.append("bar", "", []byte(`{}`))
+	assert.NoError(t, err)
+	crashed.Attempts = 3
+	assert.NoError(t, j.save(crashed))
+
+	var handled []string
+	s := NewServer(nil, "", "127.0.0.1", 0)
+	assert.NoError(t, s.Handle("/", func(tradehook string, payload []byte) {
+		handled = append(handled, tradehook)
+	}, WithJournal(JournalOptions{Dir: dir, MaxAttempts: 3})))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() {
+		stopped <- s.Start(ctx)
+	}()
+
+	// Journal is replayed before the server starts listening
+	assert.Eventually(t, func() bool {
+		return s.ListenAddr() != ""
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{"order_received", "order_filled"}, handled)
+
+	pending, _ := ioutil.ReadDir(filepath.Join(dir, "pending"))
+	assert.Empty(t, pending)
+	dead, _ := ioutil.ReadDir(filepath.Join(dir, "dead"))
+	assert.Len(t, dead, 1)
+
+	// Second Start fails without replaying the journal again
+	_, err = j.append("order_canceled", "", []byte(`{}`))
+	assert.NoError(t, err)
+	assert.Error(t, s.Start(context.Background()))
+	assert.Equal(t, []string{"order_received", "order_filled"}, handled)
+
+	cancel()
+	assert.NoError(t, <-stopped)
+}
+
+// failingStore is a DedupStore which can't record ids
+type failingStore struct{}
+
+func (failingStore) Add(id string, expires time.Time) (bool, error) {
+	return false, errors.New("disk is full")
+}
+
+func (failingStore) Remove(id string) error {
+	return nil
+}
+
+func TestJournalRecordsDedupIDWithEvent(t *testing.T) {
+	dir := t.TempDir()
+
+	// Process crashed after the tradehook was journaled, but before its id was recorded
+	j, err := newJournal(JournalOptions{Dir: filepath.Join(dir, "journal")})
+	assert.NoError(t, err)
+	_, err = j.append("order_filled", "1", []byte(`{}`))
+	assert.NoError(t, err)
+
+	store, err := NewFileStore(filepath.Join(dir, "dedup.log"))
+	assert.NoError(t, err)
+	defer store.Close()
+
+	var mu sync.Mutex
+	var handled []string
+	s := NewServer(nil, "", "127.0.0.1", 0)
+	assert.NoError(t, s.Handle("/", func(tradehook string, payload []byte) {
+		mu.Lock()
+		handled = append(handled, tradehook)
+		mu.Unlock()
+	}, WithJournal(JournalOptions{Dir: filepath.Join(dir, "journal")}), WithDedup(DedupOptions{Store: store})))
+	assert.NoError(t, s.replay())
+
+	// Redelivery of the replayed tradehook is a duplicate
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"event":"order_filled","data":{}}`))
+	req.Header.Set(EventIDHeader, "1")
+	res := httptest.NewRecorder()
+	s.Handler().ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, []string{"order_filled"}, handled)
+
+	pending, _ := ioutil.ReadDir(filepath.Join(dir, "journal", "pending"))
+	assert.Empty(t, pending)
+}
+
+func TestJournalDiscardsEventIfDedupFails(t *testing.T) {
+	dir := t.TempDir()
+
+	var calls int
+	s := NewServer(nil, "", "127.0.0.1", 0)
+	assert.NoError(t, s.Handle("/", func(tradehook string, payload []byte) {
+		calls++
+	}, WithJournal(JournalOptions{Dir: dir}), WithDedup(DedupOptions{Store: failingStore{}}),
+		WithErrorHandler(func(r *http.Request, err *RequestError) {})))
+
+	res := httptest.NewRecorder()
+	s.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"event":"order_filled","data":{}}`)))
+	assert.Equal(t, http.StatusInternalServerError, res.Code)
+
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, 0, calls)
+
+	pending, _ := ioutil.ReadDir(filepath.Join(dir, "pending"))
+	assert.Empty(t, pending)
+}
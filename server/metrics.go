@@ -0,0 +1,230 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Default paths of the server probes and metrics, which are served unless their Server fields are emptied
+const (
+	DefaultHealthPath  = "/healthz"
+	DefaultReadyPath   = "/readyz"
+	DefaultMetricsPath = "/metrics"
+)
+
+// otherTradehook is a metrics label of tradehooks which aren't known event kinds
+const otherTradehook = "other"
+
+// knownTradehooks are event kinds counted under own label, so senders can't grow metrics without bound
+var knownTradehooks = map[string]bool{
+	"bar":                    true,
+	"order":                  true,
+	"order_received":         true,
+	"order_pending":          true,
+	"order_submitted":        true,
+	"order_sent":             true,
+	"order_accepted":         true,
+	"order_partially_filled": true,
+	"order_filled":           true,
+	"order_pending_cancel":   true,
+	"order_canceled":         true,
+	"order_expired":          true,
+	"order_rejected":         true,
+	"price":                  true,
+	"price_expire":           true,
+	"position":               true,
+	"position_expire":        true,
+	"error":                  true,
+}
+
+// latencyBuckets are upper bounds of strategy handler latency histogram in seconds
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metrics are counters of an endpoint
+type metrics struct {
+	mu           sync.Mutex
+	received     map[string]uint64
+	errors       map[string]uint64
+	buckets      []uint64
+	latencySum   float64
+	latencyCount uint64
+}
+
+// newMetrics create new empty metrics
+func newMetrics() *metrics {
+	return &metrics{
+		received: make(map[string]uint64),
+		errors:   make(map[string]uint64),
+		buckets:  make([]uint64, len(latencyBuckets)),
+	}
+}
+
+// receive counts decoded tradehook, unknown event kinds are counted together
+func (m *metrics) receive(tradehook string) {
+	if !knownTradehooks[tradehook] {
+		tradehook = otherTradehook
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.received[tradehook]++
+}
+
+// fail counts failed request by error id
+func (m *metrics) fail(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.errors[id]++
+}
+
+// observe records strategy handler latency
+func (m *metrics) observe(latency time.Duration) {
+	seconds := latency.Seconds()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			m.buckets[i]++
+			break
+		}
+	}
+	m.latencySum += seconds
+	m.latencyCount++
+}
+
+// healthHandler answers 200 while the process is able to serve requests
+func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
+	writeOK(w)
+}
+
+// readyHandler answers 200 when the server is started, isn't shutting down and passes ReadinessCheck,
+// or 503 otherwise
+func (s *Server) readyHandler(w http.ResponseWriter, r *http.Request) {
+	err := s.checkReady()
+	if err == nil {
+		writeOK(w)
+		return
+	}
+
+	w.WriteHeader(http.StatusServiceUnavailable)
+	if _, err = w.Write([]byte(err.Error())); err != nil {
+		log.Println(err)
+	}
+}
+
+// checkReady returns the reason the server isn't ready
+func (s *Server) checkReady() error {
+	if atomic.LoadInt32(&s.ready) == 0 {
+		return errors.New("server is not started")
+	}
+	if s.ReadinessCheck != nil {
+		return s.ReadinessCheck()
+	}
+	return nil
+}
+
+// metricsHandler writes metrics of the endpoints in Prometheus text format
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	endpoints := s.endpointMap()
+	paths := make([]string, 0, len(endpoints))
+	for path := range endpoints {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+
+	writeHeader(&b, "tradehooks_received_total", "counter", "Tradehooks received by event.")
+	for _, path := range paths {
+		e := endpoints[path]
+		e.metrics.mu.Lock()
+		for _, tradehook := range sortedKeys(e.metrics.received) {
+			fmt.Fprintf(&b, "tradehooks_received_total{endpoint=%s,tradehook=%s} %d\n",
+				label(path), label(tradehook), e.metrics.received[tradehook])
+		}
+		e.metrics.mu.Unlock()
+	}
+
+	writeHeader(&b, "tradehook_errors_total", "counter", "Failed tradehook requests by error id.")
+	for _, path := range paths {
+		e := endpoints[path]
+		e.metrics.mu.Lock()
+		for _, id := range sortedKeys(e.metrics.errors) {
+			fmt.Fprintf(&b, "tradehook_errors_total{endpoint=%s,id=%s} %d\n", label(path), label(id), e.metrics.errors[id])
+		}
+		e.metrics.mu.Unlock()
+	}
+
+	writeHeader(&b, "tradehook_duplicates_total", "counter", "Redelivered tradehooks skipped by deduplication.")
+	for _, path := range paths {
+		fmt.Fprintf(&b, "tradehook_duplicates_total{endpoint=%s} %d\n", label(path), atomic.LoadUint64(&endpoints[path].duplicates))
+	}
+
+	writeHeader(&b, "tradehook_handler_duration_seconds", "histogram", "Latency of strategy handler.")
+	for _, path := range paths {
+		e := endpoints[path]
+		e.metrics.mu.Lock()
+		var count uint64
+		for i, bound := range latencyBuckets {
+			count += e.metrics.buckets[i]
+			fmt.Fprintf(&b, "tradehook_handler_duration_seconds_bucket{endpoint=%s,le=\"%s\"} %d\n",
+				label(path), strconv.FormatFloat(bound, 'g', -1, 64), count)
+		}
+		fmt.Fprintf(&b, "tradehook_handler_duration_seconds_bucket{endpoint=%s,le=\"+Inf\"} %d\n", label(path), e.metrics.latencyCount)
+		fmt.Fprintf(&b, "tradehook_handler_duration_seconds_sum{endpoint=%s} %s\n",
+			label(path), strconv.FormatFloat(e.metrics.latencySum, 'g', -1, 64))
+		fmt.Fprintf(&b, "tradehook_handler_duration_seconds_count{endpoint=%s} %d\n", label(path), e.metrics.latencyCount)
+		e.metrics.mu.Unlock()
+	}
+
+	writeHeader(&b, "tradehook_queue_depth", "gauge", "Tradehooks waiting in asynchronous endpoint queue.")
+	for _, path := range paths {
+		if q := endpoints[path].queue; q != nil {
+			fmt.Fprintf(&b, "tradehook_queue_depth{endpoint=%s} %d\n", label(path), q.stats().Depth)
+		}
+	}
+
+	writeHeader(&b, "tradehook_queue_capacity", "gauge", "Capacity of asynchronous endpoint queue.")
+	for _, path := range paths {
+		if q := endpoints[path].queue; q != nil {
+			fmt.Fprintf(&b, "tradehook_queue_capacity{endpoint=%s} %d\n", label(path), q.stats().Capacity)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if _, err := w.Write([]byte(b.String())); err != nil {
+		log.Println(err)
+	}
+}
+
+// writeHeader writes HELP and TYPE lines of the metric
+func writeHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// label returns quoted and escaped label value
+func label(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value) + `"`
+}
+
+// sortedKeys returns keys of the counters in alphabetical order
+func sortedKeys(counters map[string]uint64) []string {
+	keys := make([]string, 0, len(counters))
+	for key := range counters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
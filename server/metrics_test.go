@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServerProbes(t *testing.T) {
+	s := NewServer(func(tradehook string, payload []byte) {}, "/", "127.0.0.1", 0)
+	s.Auth = &Auth{Token: "secret"}
+
+	get := func(path string) (int, string) {
+		res := httptest.NewRecorder()
+		s.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, path, nil))
+		body, _ := ioutil.ReadAll(res.Body)
+		return res.Code, string(body)
+	}
+
+	// Probes are served at default paths
+	assert.Equal(t, DefaultHealthPath, s.HealthPath)
+	assert.Equal(t, DefaultReadyPath, s.ReadyPath)
+	assert.Equal(t, DefaultMetricsPath, s.MetricsPath)
+
+	var notReady error
+	s.ReadinessCheck = func() error {
+		return notReady
+	}
+
+	// Probes aren't authenticated, the server isn't ready until it's started
+	code, _ := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	code, body := get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "server is not started", body)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() {
+		stopped <- s.Start(ctx)
+	}()
+	assert.Eventually(t, func() bool {
+		code, _ := get("/readyz")
+		return code == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+
+	notReady = errors.New("strategy is warming up")
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "strategy is warming up", body)
+
+	cancel()
+	assert.NoError(t, <-stopped)
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+
+	// Disabled probes fall through to the authenticated strategy endpoint
+	s.HealthPath, s.ReadyPath, s.MetricsPath = "", "", ""
+	for _, path := range []string{DefaultHealthPath, DefaultReadyPath, DefaultMetricsPath} {
+		code, _ := get(path)
+		assert.Equal(t, http.StatusUnauthorized, code, path)
+	}
+}
+
+func TestServerMetrics(t *testing.T) {
+	s := NewServer(nil, "", "127.0.0.1", 0)
+	assert.NoError(t, s.Handle("/a", func(tradehook string, payload []byte) {
+		if tradehook == "order_rejected" {
+			panic("boom")
+		}
+	}, WithErrorHandler(func(r *http.Request, err *RequestError) {})))
+	assert.NoError(t, s.Handle("/b", func(tradehook string, payload []byte) {}, WithAsync(AsyncOptions{Workers: 1, QueueSize: 8})))
+
+	post := func(path, body string) {
+		res := httptest.NewRecorder()
+		s.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(body)))
+	}
+	post("/a", `{"event":"order_filled","data":{}}`)
+	post("/a", `{"event":"order_filled","data":{}}`)
+	post("/a", `{"event":"order_rejected","data":{}}`)
+	post("/a", `{"data":{}}`)
+	post("/a", `{"event":"foo","data":{}}`)
+	post("/a", `{"event":"bar-1","data":{}}`)
+	post("/b", `{"event":"bar","data":{}}`)
+
+	res := httptest.NewRecorder()
+	s.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	body := res.Body.String()
+	assert.Contains(t, body, "# TYPE tradehooks_received_total counter\n")
+	assert.Contains(t, body, `tradehooks_received_total{endpoint="/a",tradehook="order_filled"} 2`)
+	assert.Contains(t, body, `tradehooks_received_total{endpoint="/a",tradehook="order_rejected"} 1`)
+	assert.Contains(t, body, `tradehooks_received_total{endpoint="/b",tradehook="bar"} 1`)
+	assert.Contains(t, body, `tradehooks_received_total{endpoint="/a",tradehook="other"} 2`)
+	assert.NotContains(t, body, `tradehook="foo"`)
+	assert.Contains(t, body, `tradehook_errors_total{endpoint="/a",id="internal_server_error"} 1`)
+	assert.Contains(t, body, `tradehook_errors_total{endpoint="/a",id="missing_event"} 1`)
+	assert.Contains(t, body, `tradehook_handler_duration_seconds_bucket{endpoint="/a",le="+Inf"} 5`)
+	assert.Contains(t, body, `tradehook_handler_duration_seconds_count{endpoint="/a"} 5`)
+	assert.Contains(t, body, `tradehook_queue_capacity{endpoint="/b"} 8`)
+	assert.NotContains(t, body, `tradehook_queue_depth{endpoint="/a"}`)
+}
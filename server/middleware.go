@@ -0,0 +1,119 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Event is a decoded tradehook passed through the middleware chain to the strategy
+type Event struct {
+	// Tradehook is an event name, e.g. "order_filled"
+	Tradehook string
+
+	// Payload is a raw "data" of the tradehook
+	Payload []byte
+
+	// Request is the HTTP request of the tradehook, it's nil for events replayed from the journal.
+	// Events handled by WithAsync or WithJournal workers after the request is answered get its copy
+	// with empty body, which context keeps request values, but is never cancelled
+	Request *http.Request
+}
+
+// EventHandler handles decoded tradehook. Returned *RequestError answers the request with its status
+// and id, other errors are answered with 500
+type EventHandler func(event *Event) error
+
+// Middleware wraps EventHandler, e.g. to log, trace or rate limit tradehooks; it rejects the event
+// by returning an error without calling next
+type Middleware func(next EventHandler) EventHandler
+
+// WithEventMiddleware wraps strategy of the endpoint, the first middleware is the outermost
+func WithEventMiddleware(middleware ...Middleware) EndpointOption {
+	return func(e *endpoint) {
+		e.eventMiddleware = append(e.eventMiddleware, middleware...)
+	}
+}
+
+// Use wraps strategies of all endpoints with middleware, which runs before endpoint middleware;
+// it must be called before the server is started
+func (s *Server) Use(middleware ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.middleware = append(s.middleware, middleware...)
+	for _, e := range s.endpoints {
+		e.serverMiddleware = s.middleware
+		e.buildHandler()
+	}
+}
+
+// buildHandler wraps strategy of the endpoint with server and endpoint middleware
+func (e *endpoint) buildHandler() {
+	h := func(event *Event) error {
+		e.strategy(event.Tradehook, event.Payload)
+		return nil
+	}
+
+	middleware := append(append([]Middleware{}, e.serverMiddleware...), e.eventMiddleware...)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	e.handle = h
+}
+
+// Recovery returns middleware which turns strategy panics into 500 errors and calls onPanic if it's set.
+// Strategy panics are recovered by the server anyway, Recovery lets outer middleware see them as errors
+func Recovery(onPanic func(event *Event, p interface{}, stack []byte)) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(event *Event) (err error) {
+			defer func() {
+				if p := recover(); p != nil {
+					stack := debug.Stack()
+					if onPanic != nil {
+						onPanic(event, p, stack)
+					}
+					err = &RequestError{
+						Status:    http.StatusInternalServerError,
+						ID:        "internal_server_error",
+						Message:   fmt.Sprintf("Strategy panic: %v", p),
+						Tradehook: event.Tradehook,
+						Stack:     stack,
+					}
+				}
+			}()
+
+			return next(event)
+		}
+	}
+}
+
+// Logging returns middleware which logs each tradehook, its handling time and error;
+// the standard logger is used if logger is nil
+func Logging(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	return Timing(func(event *Event, elapsed time.Duration, err error) {
+		if err != nil {
+			logger.Printf("tradehook %s failed in %s: %v", event.Tradehook, elapsed, err)
+			return
+		}
+		logger.Printf("tradehook %s handled in %s", event.Tradehook, elapsed)
+	})
+}
+
+// Timing returns middleware which calls observe with handling time and error of each tradehook
+func Timing(observe func(event *Event, elapsed time.Duration, err error)) Middleware {
+	return func(next EventHandler) EventHandler {
+		return func(event *Event) error {
+			start := time.Now()
+			err := next(event)
+			observe(event, time.Since(start), err)
+			return err
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEventMiddlewareChain(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next EventHandler) EventHandler {
+			return func(event *Event) error {
+				calls = append(calls, name+":"+event.Tradehook)
+				return next(event)
+			}
+		}
+	}
+	limit := func(next EventHandler) EventHandler {
+		return func(event *Event) error {
+			if event.Request.Header.Get("X-Limited") != "" {
+				return &RequestError{Status: http.StatusTooManyRequests, ID: "rate_limited", Message: "Too many tradehooks"}
+			}
+			return next(event)
+		}
+	}
+
+	s := NewServer(func(tradehook string, payload []byte) {
+		calls = append(calls, "strategy:"+string(payload))
+	}, "/", "127.0.0.1", 0)
+	s.Use(trace("server"))
+	assert.NoError(t, s.Handle("/limited", func(tradehook string, payload []byte) {
+		calls = append(calls, "limited:"+string(payload))
+	}, WithEventMiddleware(trace("endpoint"), limit), WithErrorHandler(func(r *http.Request, err *RequestError) {})))
+
+	post := func(path string, limited bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewBufferString(`{"event":"bar","data":{}}`))
+		if limited {
+			req.Header.Set("X-Limited", "1")
+		}
+		res := httptest.NewRecorder()
+		s.Handler().ServeHTTP(res, req)
+		return res
+	}
+
+	// Server middleware wraps endpoints registered before and after Use
+	assert.Equal(t, http.StatusOK, post("/", false).Code)
+	assert.Equal(t, http.StatusOK, post("/limited", false).Code)
+	assert.Equal(t, []string{"server:bar", "strategy:{}", "server:bar", "endpoint:bar", "limited:{}"}, calls)
+
+	calls = nil
+	res := post("/limited", true)
+	assert.Equal(t, http.StatusTooManyRequests, res.Code)
+	assert.JSONEq(t, `{"errors":[{"id":"rate_limited","message":"Too many tradehooks"}]}`, res.Body.String())
+	assert.Equal(t, []string{"server:bar", "endpoint:bar"}, calls)
+}
+
+func TestRecoveryLoggingAndTimingMiddleware(t *testing.T) {
+	var logs bytes.Buffer
+	var panics []interface{}
+	var observed []error
+
+	s := NewServer(nil, "", "127.0.0.1", 0)
+	assert.NoError(t, s.Handle("/", func(tradehook string, payload []byte) {
+		if tradehook == "order_rejected" {
+			panic("boom")
+		}
+	}, WithEventMiddleware(
+		Logging(log.New(&logs, "", 0)),
+		Timing(func(event *Event, elapsed time.Duration, err error) {
+			observed = append(observed, err)
+		}),
+		Recovery(func(event *Event, p interface{}, stack []byte) {
+			panics = append(panics, p)
+		}),
+	), WithErrorHandler(func(r *http.Request, err *RequestError) {})))
+
+	for _, body := range []string{`{"event":"order_filled","data":{}}`, `{"event":"order_rejected","data":{}}`} {
+		res := httptest.NewRecorder()
+		s.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body)))
+		if body == `{"event":"order_filled","data":{}}` {
+			assert.Equal(t, http.StatusOK, res.Code)
+		} else {
+			assert.Equal(t, http.StatusInternalServerError, res.Code)
+		}
+	}
+
+	assert.Equal(t, []interface{}{"boom"}, panics)
+	assert.Len(t, observed, 2)
+	assert.NoError(t, observed[0])
+	assert.EqualError(t, observed[1], "internal_server_error: Strategy panic: boom")
+	assert.Contains(t, logs.String(), "tradehook order_filled handled in ")
+	assert.Contains(t, logs.String(), "tradehook order_rejected failed in ")
+}
+
+func TestQueuedEventsGetDetachedRequest(t *testing.T) {
+	type traceKey struct{}
+	release := make(chan struct{})
+
+	var ctxErr error
+	var trace interface{}
+	var header string
+	s := NewServer(nil, "", "127.0.0.1", 0)
+	assert.NoError(t, s.Handle("/", func(tradehook string, payload []byte) {}, WithAsync(AsyncOptions{}),
+		WithEventMiddleware(func(next EventHandler) EventHandler {
+			return func(event *Event) error {
+				<-release
+				ctxErr, trace = event.Request.Context().Err(), event.Request.Context().Value(traceKey{})
+				header = event.Request.Header.Get("X-Trace")
+				return next(event)
+			}
+		})))
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), traceKey{}, "trace-1"))
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"event":"bar","data":{}}`)).WithContext(ctx)
+	req.Header.Set("X-Trace", "trace-1")
+	res := httptest.NewRecorder()
+	s.Handler().ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	// Request is answered, so net/http cancels its context before the worker handles the event
+	cancel()
+	close(release)
+	assert.NoError(t, s.Shutdown(context.Background()))
+
+	assert.NoError(t, ctxErr)
+	assert.Equal(t, "trace-1", trace)
+	assert.Equal(t, "trace-1", header)
+}
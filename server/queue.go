@@ -0,0 +1,325 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	DefaultQueueWorkers = 4
+	DefaultQueueSize    = 1024
+)
+
+var (
+	errQueueFull   = errors.New("tradehook queue is full")
+	errQueueClosed = errors.New("tradehook queue is closed")
+)
+
+// AsyncOptions configure asynchronous tradehook processing of an endpoint
+type AsyncOptions struct {
+	// Workers is a number of goroutines calling the strategy, DefaultQueueWorkers is used by default
+	Workers int
+
+	// QueueSize is a capacity of each worker queue, DefaultQueueSize is used by default
+	QueueSize int
+
+	// EnqueueTimeout is a maximum time to wait for a free queue slot before the tradehook is rejected
+	// with 503, so the platform redelivers it later; full queue rejects immediately by default
+	EnqueueTimeout time.Duration
+
+	// Key returns ordering key of the tradehook, tradehooks with the same key are handled
+	// in the order they were received. AssetKey is used by default
+	Key func(tradehook string, payload []byte) string
+}
+
+// QueueStats are counters of an asynchronous endpoint queue
+type QueueStats struct {
+	Depth     int    `json:"depth"`
+	Capacity  int    `json:"capacity"`
+	Enqueued  uint64 `json:"enqueued"`
+	Processed uint64 `json:"processed"`
+	Rejected  uint64 `json:"rejected"`
+}
+
+// WithAsync acknowledges tradehooks of the endpoint immediately and handles them by worker goroutines
+func WithAsync(opts AsyncOptions) EndpointOption {
+	return func(e *endpoint) {
+		e.async = &opts
+	}
+}
+
+// AssetKey returns asset of the tradehook, so events of the same asset are handled in order: "asset" field
+// of the payload or of its "order", e.g. of order events, asset of the monitored position or the monitor rule,
+// or the only asset of a bar. Bars of several assets and tradehooks without asset, e.g. "error", share
+// the empty key, so they're handled in order with each other, but not with events of a single asset;
+// use a single worker or own Key if bars of several assets must be ordered with their order events
+func AssetKey(tradehook string, payload []byte) string {
+	var data struct {
+		Asset    string          `json:"asset"`
+		Order    json.RawMessage `json:"order"`
+		Position json.RawMessage `json:"position"`
+		Rule     json.RawMessage `json:"rule"`
+		Assets   json.RawMessage `json:"assets"`
+		Bars     json.RawMessage `json:"bars"`
+	}
+	if err := json.Unmarshal(payload, &data); err != nil {
+		return ""
+	}
+	if data.Asset != "" {
+		return data.Asset
+	}
+
+	for _, nested := range []json.RawMessage{data.Order, data.Position, data.Rule} {
+		if asset := assetOf(nested); asset != "" {
+			return asset
+		}
+	}
+
+	var assets []string
+	if len(data.Assets) > 0 && json.Unmarshal(data.Assets, &assets) != nil {
+		return ""
+	}
+	if len(assets) == 1 {
+		return assets[0]
+	}
+	if len(assets) == 0 && len(data.Bars) > 0 {
+		// Bar without asset list is keyed by the assets of its bars, which are keyed by datetime and asset
+		var bars map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(data.Bars, &bars); err != nil {
+			return ""
+		}
+
+		var asset string
+		for _, assetBars := range bars {
+			for a := range assetBars {
+				if asset != "" && a != asset {
+					return ""
+				}
+				asset = a
+			}
+		}
+		return asset
+	}
+	return ""
+}
+
+// assetOf returns "asset" field of the nested JSON object or an empty string
+func assetOf(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+
+	var data struct {
+		Asset string `json:"asset"`
+	}
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return ""
+	}
+	return data.Asset
+}
+
+// job is a queued tradehook
+type job struct {
+	tradehook string
+	eventID   string
+	data      []byte
+	request   *http.Request
+	entry     *journalEntry
+}
+
+// detachedContext keeps values of the request context, but isn't cancelled when the request is answered
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// detach returns copy of the request for workers, which handle it after it's answered; its context keeps
+// values without cancellation and its body is empty, as it's already read
+func detach(r *http.Request) *http.Request {
+	detached := r.Clone(detachedContext{r.Context()})
+	detached.Body = http.NoBody
+	return detached
+}
+
+// queue is a set of bounded worker queues, tradehooks are assigned to workers by key hash
+type queue struct {
+	// Counters are first to keep 64-bit alignment for atomic access
+	enqueued  uint64
+	processed uint64
+	rejected  uint64
+
+	mu       sync.RWMutex
+	closed   bool
+	quit     chan struct{}
+	quitOnce sync.Once
+	workers  []chan job
+	wg       sync.WaitGroup
+	key      func(tradehook string, payload []byte) string
+	timeout  time.Duration
+	size     int
+	handler  func(j job)
+}
+
+// newQueue create new queue and start workers calling handler
+func newQueue(opts AsyncOptions, handler func(j job)) *queue {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultQueueWorkers
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultQueueSize
+	}
+	if opts.Key == nil {
+		opts.Key = AssetKey
+	}
+
+	q := &queue{
+		key:     opts.Key,
+		timeout: opts.EnqueueTimeout,
+		quit:    make(chan struct{}),
+		workers: make([]chan job, opts.Workers),
+		size:    opts.QueueSize,
+		handler: handler,
+	}
+	q.start()
+	return q
+}
+
+// start creates worker queues and starts their goroutines
+func (q *queue) start() {
+	for i := range q.workers {
+		jobs := make(chan job, q.size)
+		q.workers[i] = jobs
+
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for j := range jobs {
+				q.handler(j)
+				atomic.AddUint64(&q.processed, 1)
+			}
+		}()
+	}
+}
+
+// reopen starts workers of the closed queue again, so a restarted server handles tradehooks
+func (q *queue) reopen() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		return
+	}
+	q.closed = false
+	q.quit = make(chan struct{})
+	q.quitOnce = sync.Once{}
+	q.workers = make([]chan job, len(q.workers))
+	q.start()
+}
+
+// enqueue adds tradehook to the queue of its key worker
+func (q *queue) enqueue(j job) error {
+	h := fnv.New32a()
+	h.Write([]byte(q.key(j.tradehook, j.data)))
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		atomic.AddUint64(&q.rejected, 1)
+		return errQueueClosed
+	}
+
+	jobs := q.workers[h.Sum32()%uint32(len(q.workers))]
+	select {
+	case jobs <- j:
+		atomic.AddUint64(&q.enqueued, 1)
+		return nil
+	default:
+	}
+
+	if q.timeout > 0 {
+		timer := time.NewTimer(q.timeout)
+		defer timer.Stop()
+
+		// Closing queue wakes waiting senders, so close doesn't wait for their timeout to take the lock
+		select {
+		case jobs <- j:
+			atomic.AddUint64(&q.enqueued, 1)
+			return nil
+		case <-q.quit:
+			atomic.AddUint64(&q.rejected, 1)
+			return errQueueClosed
+		case <-timer.C:
+		}
+	}
+
+	atomic.AddUint64(&q.rejected, 1)
+	return errQueueFull
+}
+
+// stats returns current queue counters
+func (q *queue) stats() QueueStats {
+	stats := QueueStats{
+		Enqueued:  atomic.LoadUint64(&q.enqueued),
+		Processed: atomic.LoadUint64(&q.processed),
+		Rejected:  atomic.LoadUint64(&q.rejected),
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	for _, jobs := range q.workers {
+		stats.Depth += len(jobs)
+		stats.Capacity += cap(jobs)
+	}
+	return stats
+}
+
+// close stops accepting tradehooks and waits until queued ones are handled or the context is done
+func (q *queue) close(ctx context.Context) error {
+	// Waiting senders hold the read lock, so quit is closed under it to wake them
+	q.mu.RLock()
+	q.quitOnce.Do(func() {
+		close(q.quit)
+	})
+	q.mu.RUnlock()
+
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		for _, jobs := range q.workers {
+			close(jobs)
+		}
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
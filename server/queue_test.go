@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAsyncEndpointKeepsOrderPerAsset(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string][]int)
+
+	s := NewServer(nil, "", "127.0.0.1", 0)
+	assert.NoError(t, s.Handle("/", func(tradehook string, payload []byte) {
+		var n int
+		var asset string
+		fmt.Sscanf(string(payload), `{"asset":"%1s","n":%d}`, &asset, &n)
+
+		mu.Lock()
+		received[asset] = append(received[asset], n)
+		mu.Unlock()
+	}, WithAsync(AsyncOptions{Workers: 3, QueueSize: 200})))
+
+	handler := s.Handler()
+	for n := 0; n < 50; n++ {
+		for _, asset := range []string{"A", "B", "C", "D"} {
+			body := fmt.Sprintf(`{"event":"order_filled","data":{"asset":"%s","n":%d}}`, asset, n)
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(body)))
+			assert.Equal(t, http.StatusOK, res.Code)
+		}
+	}
+
+	assert.NoError(t, s.Shutdown(context.Background()))
+
+	for _, asset := range []string{"A", "B", "C", "D"} {
+		assert.Equal(t, 50, len(received[asset]), asset)
+		for i, n := range received[asset] {
+			assert.Equal(t, i, n, asset)
+		}
+	}
+
+	stats := s.QueueStats()["/"]
+	assert.Equal(t, uint64(200), stats.Enqueued)
+	assert.Equal(t, uint64(200), stats.Processed)
+	assert.Equal(t, 0, stats.Depth)
+	assert.Equal(t, 600, stats.Capacity)
+}
+
+func TestAssetKey(t *testing.T) {
+	for payload, expected := range map[string]string{
+		`{"order_id":"1","asset":"AAPL","status":"filled"}`:                                         "AAPL",
+		`{"order":{"asset":"AAPL"}}`:                                                                "AAPL",
+		`{"event":"position_expire","rule":{},"position":{"asset":"AAPL","qty":1}}`:                 "AAPL",
+		`{"event":"price_expire","rule":{"asset":"AAPL","price":100}}`:                              "AAPL",
+		`{"assets":["AAPL"],"bars":{"2021-01-04 00:00:00":{"AAPL":{"c":1}}}}`:                       "AAPL",
+		`{"bars":{"2021-01-04 00:00:00":{"AAPL":{"c":1}},"2021-01-05 00:00:00":{"AAPL":{"c":2}}}}`:  "AAPL",
+		`{"assets":["AAPL","MSFT"],"bars":{"2021-01-04 00:00:00":{"AAPL":{"c":1},"MSFT":{"c":2}}}}`: "",
+		`[{"id":"foo","message":"bar"}]`:                                                            "",
+	} {
+		assert.Equal(t, expected, AssetKey("", []byte(payload)), payload)
+	}
+}
+
+func TestAsyncEndpointAppliesBackpressure(t *testing.T) {
+	release := make(chan struct{})
+	s := NewServer(nil, "", "127.0.0.1", 0)
+	assert.NoError(t, s.Handle("/", func(tradehook string, payload []byte) {
+		<-release
+	}, WithAsync(AsyncOptions{Workers: 1, QueueSize: 1, EnqueueTimeout: 10 * time.Millisecond})))
+
+	handler := s.Handler()
+	post := func() *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"event":"bar","data":{}}`)))
+		return res
+	}
+
+	// The first tradehook is taken by the worker and the second one fills the queue
+	assert.Equal(t, http.StatusOK, post().Code)
+	assert.Eventually(t, func() bool {
+		return s.QueueStats()["/"].Depth == 0
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, http.StatusOK, post().Code)
+
+	res := post()
+	assert.Equal(t, http.StatusServiceUnavailable, res.Code)
+	assert.Equal(t, "1", res.Header().Get("Retry-After"))
+	assert.Equal(t, QueueStats{Depth: 1, Capacity: 1, Enqueued: 2, Rejected: 1}, s.QueueStats()["/"])
+
+	close(release)
+	assert.NoError(t, s.Shutdown(context.Background()))
+	assert.Equal(t, http.StatusServiceUnavailable, post().Code)
+}
+
+func TestAsyncEndpointShutdownWakesWaitingRequests(t *testing.T) {
+	release := make(chan struct{})
+	s := NewServer(nil, "", "127.0.0.1", 0)
+	assert.NoError(t, s.Handle("/", func(tradehook string, payload []byte) {
+		<-release
+	}, WithAsync(AsyncOptions{Workers: 1, QueueSize: 1, EnqueueTimeout: time.Minute})))
+
+	handler := s.Handler()
+	post := func() int {
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"event":"bar","data":{}}`)))
+		return res.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post())
+	assert.Eventually(t, func() bool {
+		return s.QueueStats()["/"].Depth == 0
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, http.StatusOK, post())
+
+	// The request waits for a free slot until the queue is closed rather than for the whole timeout
+	waiting := make(chan int, 1)
+	go func() {
+		waiting <- post()
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+
+	select {
+	case code := <-waiting:
+		assert.Equal(t, http.StatusServiceUnavailable, code)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown didn't wake the waiting request")
+	}
+
+	close(release)
+	assert.NoError(t, <-shutdown)
+}
+
+func TestAsyncEndpointReportsPanics(t *testing.T) {
+	reported := make(chan *RequestError, 1)
+	s := NewServer(nil, "", "127.0.0.1", 0)
+	assert.NoError(t, s.Handle("/", func(tradehook string, payload []byte) {
+		panic("boom")
+	}, WithAsync(AsyncOptions{}), WithErrorHandler(func(r *http.Request, err *RequestError) {
+		reported <- err
+	})))
+
+	res := httptest.NewRecorder()
+	s.Handler().ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"event":"bar","data":{}}`)))
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	err := <-reported
+	assert.Equal(t, "bar", err.Tradehook)
+	assert.Equal(t, http.StatusInternalServerError, err.Status)
+	assert.NoError(t, s.Shutdown(context.Background()))
+}
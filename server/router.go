@@ -0,0 +1,306 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+)
+
+// OHLCV is a single bar of an asset
+type OHLCV struct {
+	Open   float64 `json:"o"`
+	High   float64 `json:"h"`
+	Low    float64 `json:"l"`
+	Close  float64 `json:"c"`
+	Volume float64 `json:"v"`
+}
+
+// Bar is a payload of "bar" tradehook, bars are keyed by datetime and asset
+type Bar struct {
+	Assets     []string                    `json:"assets"`
+	Resolution string                      `json:"resolution,omitempty"`
+	Bars       map[string]map[string]OHLCV `json:"bars"`
+}
+
+// Order is a payload of "order" tradehook, its status tells the event, e.g. "filled". Backtest runtime
+// events carry the same payload under event kinds, e.g. "order_filled"
+type Order struct {
+	OrderID       string   `json:"order_id"`
+	Asset         string   `json:"asset"`
+	Side          string   `json:"side"`
+	Type          string   `json:"type"`
+	Tif           string   `json:"tif"`
+	ExtendedHours bool     `json:"extended_hours"`
+	Qty           float64  `json:"qty"`
+	FilledQty     float64  `json:"filled_qty"`
+	LimitPrice    *float64 `json:"limit_price"`
+	StopPrice     *float64 `json:"stop_price"`
+	AvgFillPrice  *float64 `json:"avg_fill_price"`
+	Status        string   `json:"status"`
+	SubmittedAt   string   `json:"submitted_at"`
+	AcceptedAt    *string  `json:"accepted_at"`
+	CreatedAt     *string  `json:"created_at"`
+	UpdatedAt     *string  `json:"updated_at"`
+	FilledAt      *string  `json:"filled_at"`
+	CanceledAt    *string  `json:"canceled_at"`
+	ExpiredAt     *string  `json:"expired_at"`
+	RejectedAt    *string  `json:"rejected_at"`
+	Comment       *string  `json:"comment"`
+	StrategyID    string   `json:"strategy_id"`
+	AccountID     string   `json:"account_id"`
+}
+
+// Monitor is a payload of "price" and "position" tradehooks of monitors: the event, e.g. "price_expire",
+// the monitor rule and the monitored position. Rule and position are kept raw to be decoded by the strategy
+type Monitor struct {
+	Event    string          `json:"event"`
+	Rule     json.RawMessage `json:"rule"`
+	Position json.RawMessage `json:"position"`
+}
+
+// Error is a single error of "error" tradehook
+type Error struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+// Router dispatches tradehooks to handlers registered per event kind, which receive decoded payloads.
+// Event kind of "order" tradehook is resolved from the order status, e.g. "order_filled", and of monitor
+// tradehooks from the payload event, e.g. "price_expire". Order tradehooks without own handler go to OnOrder
+// handler, and any other tradehook without handler goes to the fallback. Router.Handle has the strategy
+// signature, so it can be passed to Start
+type Router struct {
+	mu            sync.RWMutex
+	handlers      map[string]func(payload []byte) error
+	orderHandler  func(tradehook string, order *Order)
+	fallback      func(tradehook string, payload []byte)
+	decodeHandler func(tradehook string, payload []byte, err error)
+}
+
+// NewRouter create new Router without handlers
+func NewRouter() *Router {
+	return &Router{handlers: make(map[string]func(payload []byte) error)}
+}
+
+// Handle decodes tradehook payload and calls its handler
+func (r *Router) Handle(tradehook string, payload []byte) {
+	kind := eventKind(tradehook, payload)
+
+	r.mu.RLock()
+	handler, ok := r.handlers[kind]
+	if !ok {
+		handler, ok = r.handlers[tradehook]
+	}
+	orderHandler, fallback, decodeHandler := r.orderHandler, r.fallback, r.decodeHandler
+	r.mu.RUnlock()
+
+	var err error
+	switch {
+	case ok:
+		err = handler(payload)
+	case orderHandler != nil && (kind == "order" || strings.HasPrefix(kind, "order_")):
+		var order Order
+		if err = json.Unmarshal(payload, &order); err == nil {
+			orderHandler(kind, &order)
+		}
+	case fallback != nil:
+		fallback(tradehook, payload)
+	}
+
+	if err != nil {
+		if decodeHandler != nil {
+			decodeHandler(tradehook, payload, err)
+			return
+		}
+		log.Printf("invalid %s tradehook payload: %v", tradehook, err)
+	}
+}
+
+// On registers handler of raw payload of selected tradehook
+func (r *Router) On(tradehook string, handler func(payload []byte)) {
+	r.handle(tradehook, func(payload []byte) error {
+		handler(payload)
+		return nil
+	})
+}
+
+// OnBar registers handler of "bar" tradehook
+func (r *Router) OnBar(handler func(bar *Bar)) {
+	r.handle("bar", func(payload []byte) error {
+		var bar Bar
+		if err := json.Unmarshal(payload, &bar); err != nil {
+			return err
+		}
+		handler(&bar)
+		return nil
+	})
+}
+
+// OnOrder registers handler of order tradehooks which have no own handler, it receives the resolved
+// event kind, e.g. "order_filled"
+func (r *Router) OnOrder(handler func(tradehook string, order *Order)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.orderHandler = handler
+}
+
+// OnOrderReceived registers handler of "order_received" event
+func (r *Router) OnOrderReceived(handler func(order *Order)) {
+	r.handleOrder("order_received", handler)
+}
+
+// OnOrderPending registers handler of "order_pending" event
+func (r *Router) OnOrderPending(handler func(order *Order)) {
+	r.handleOrder("order_pending", handler)
+}
+
+// OnOrderSubmitted registers handler of "order_submitted" event
+func (r *Router) OnOrderSubmitted(handler func(order *Order)) {
+	r.handleOrder("order_submitted", handler)
+}
+
+// OnOrderSent registers handler of "order_sent" event
+func (r *Router) OnOrderSent(handler func(order *Order)) {
+	r.handleOrder("order_sent", handler)
+}
+
+// OnOrderAccepted registers handler of "order_accepted" event
+func (r *Router) OnOrderAccepted(handler func(order *Order)) {
+	r.handleOrder("order_accepted", handler)
+}
+
+// OnOrderPartiallyFilled registers handler of "order_partially_filled" event
+func (r *Router) OnOrderPartiallyFilled(handler func(order *Order)) {
+	r.handleOrder("order_partially_filled", handler)
+}
+
+// OnOrderFilled registers handler of "order_filled" event
+func (r *Router) OnOrderFilled(handler func(order *Order)) {
+	r.handleOrder("order_filled", handler)
+}
+
+// OnOrderPendingCancel registers handler of "order_pending_cancel" event
+func (r *Router) OnOrderPendingCancel(handler func(order *Order)) {
+	r.handleOrder("order_pending_cancel", handler)
+}
+
+// OnOrderCanceled registers handler of "order_canceled" event
+func (r *Router) OnOrderCanceled(handler func(order *Order)) {
+	r.handleOrder("order_canceled", handler)
+}
+
+// OnOrderExpired registers handler of "order_expired" event
+func (r *Router) OnOrderExpired(handler func(order *Order)) {
+	r.handleOrder("order_expired", handler)
+}
+
+// OnOrderRejected registers handler of "order_rejected" event
+func (r *Router) OnOrderRejected(handler func(order *Order)) {
+	r.handleOrder("order_rejected", handler)
+}
+
+// OnPrice registers handler of price monitor events
+func (r *Router) OnPrice(handler func(monitor *Monitor)) {
+	r.handleMonitor("price", handler)
+}
+
+// OnPriceExpire registers handler of expired price monitors, "price_expire" event
+func (r *Router) OnPriceExpire(handler func(monitor *Monitor)) {
+	r.handleMonitor("price_expire", handler)
+}
+
+// OnPosition registers handler of position monitor events
+func (r *Router) OnPosition(handler func(monitor *Monitor)) {
+	r.handleMonitor("position", handler)
+}
+
+// OnPositionExpire registers handler of expired position monitors, "position_expire" event
+func (r *Router) OnPositionExpire(handler func(monitor *Monitor)) {
+	r.handleMonitor("position_expire", handler)
+}
+
+// OnError registers handler of "error" tradehook
+func (r *Router) OnError(handler func(errors []Error)) {
+	r.handle("error", func(payload []byte) error {
+		var data struct {
+			Errors []Error `json:"errors"`
+		}
+		if err := json.Unmarshal(payload, &data); err != nil {
+			return err
+		}
+		handler(data.Errors)
+		return nil
+	})
+}
+
+// Fallback registers handler of tradehooks without own handler, e.g. new event kinds
+func (r *Router) Fallback(handler func(tradehook string, payload []byte)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fallback = handler
+}
+
+// OnDecodeError registers handler of payloads which can't be decoded, they are logged by default
+func (r *Router) OnDecodeError(handler func(tradehook string, payload []byte, err error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.decodeHandler = handler
+}
+
+// handle registers payload handler of the tradehook
+func (r *Router) handle(tradehook string, handler func(payload []byte) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.handlers[tradehook] = handler
+}
+
+// handleOrder registers order handler of the tradehook
+func (r *Router) handleOrder(tradehook string, handler func(order *Order)) {
+	r.handle(tradehook, func(payload []byte) error {
+		var order Order
+		if err := json.Unmarshal(payload, &order); err != nil {
+			return err
+		}
+		handler(&order)
+		return nil
+	})
+}
+
+// handleMonitor registers monitor handler of the event kind
+func (r *Router) handleMonitor(kind string, handler func(monitor *Monitor)) {
+	r.handle(kind, func(payload []byte) error {
+		var monitor Monitor
+		if err := json.Unmarshal(payload, &monitor); err != nil {
+			return err
+		}
+		handler(&monitor)
+		return nil
+	})
+}
+
+// eventKind returns event kind of the tradehook: "order_" and the status of "order" tradehook, the payload
+// event of monitor tradehooks, e.g. "price_expire", or the tradehook itself
+func eventKind(tradehook string, payload []byte) string {
+	switch tradehook {
+	case "order":
+		var order struct {
+			Status string `json:"status"`
+		}
+		if json.Unmarshal(payload, &order) == nil && order.Status != "" {
+			return "order_" + order.Status
+		}
+	case "price", "position":
+		var monitor struct {
+			Event string `json:"event"`
+		}
+		if json.Unmarshal(payload, &monitor) == nil && strings.HasPrefix(monitor.Event, tradehook) {
+			return monitor.Event
+		}
+	}
+	return tradehook
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRouterDispatchesDecodedPayloads(t *testing.T) {
+	r := NewRouter()
+
+	var calls []string
+	r.OnBar(func(bar *Bar) {
+		calls = append(calls, "bar")
+		assert.Equal(t, []string{"AAPL"}, bar.Assets)
+		assert.Equal(t, 104.0, bar.Bars["2021-01-04T21:00:00"]["AAPL"].Close)
+	})
+	r.OnOrderFilled(func(order *Order) {
+		calls = append(calls, "filled")
+		assert.Equal(t, "1", order.OrderID)
+		assert.Equal(t, 101.5, *order.AvgFillPrice)
+	})
+	r.OnOrder(func(tradehook string, order *Order) {
+		calls = append(calls, tradehook)
+	})
+	r.OnPrice(func(monitor *Monitor) {
+		calls = append(calls, "price")
+		assert.JSONEq(t, `{"asset":"AAPL","price":99.5}`, string(monitor.Rule))
+	})
+	r.OnError(func(errs []Error) {
+		calls = append(calls, "error")
+		assert.Equal(t, []Error{{ID: "foo", Message: "bar"}}, errs)
+	})
+	r.Fallback(func(tradehook string, payload []byte) {
+		calls = append(calls, "fallback:"+tradehook)
+	})
+
+	r.Handle("bar", []byte(`{"assets":["AAPL"],"bars":{"2021-01-04T21:00:00":{"AAPL":{"o":100,"h":105,"l":99,"c":104,"v":1000}}}}`))
+	r.Handle("order_filled", []byte(`{"order_id":"1","avg_fill_price":101.5}`))
+	r.Handle("order_accepted", []byte(`{"order_id":"1"}`))
+	r.Handle("price", []byte(`{"event":"price","rule":{"asset":"AAPL","price":99.5},"position":null}`))
+	r.Handle("error", []byte(`{"errors":[{"id":"foo","message":"bar"}]}`))
+	r.Handle("monitor", []byte(`{}`))
+
+	assert.Equal(t, []string{"bar", "filled", "order_accepted", "price", "error", "fallback:monitor"}, calls)
+}
+
+func TestRouterReportsDecodeErrors(t *testing.T) {
+	r := NewRouter()
+
+	var called bool
+	r.OnPosition(func(monitor *Monitor) {
+		called = true
+	})
+
+	var decodeErr error
+	r.OnDecodeError(func(tradehook string, payload []byte, err error) {
+		assert.Equal(t, "position", tradehook)
+		decodeErr = err
+	})
+
+	r.Handle("position", []byte(`{"event":1}`))
+	assert.False(t, called)
+
+	var typeErr *json.UnmarshalTypeError
+	assert.True(t, errors.As(decodeErr, &typeErr))
+
+	// Unknown tradehook without fallback is ignored
+	r.Handle("foo", []byte(`{}`))
+}
+
+func TestRouterDispatchesSandboxPayloads(t *testing.T) {
+	r := NewRouter()
+
+	var calls []string
+	r.OnOrderFilled(func(order *Order) {
+		calls = append(calls, "filled")
+		assert.Equal(t, "AAPL", order.Asset)
+		assert.Equal(t, 10.0, order.FilledQty)
+		assert.Equal(t, 150.0, *order.AvgFillPrice)
+		assert.Nil(t, order.ExpiredAt)
+	})
+	r.OnOrder(func(tradehook string, order *Order) {
+		calls = append(calls, tradehook)
+	})
+	r.OnPositionExpire(func(monitor *Monitor) {
+		calls = append(calls, "position_expire")
+		assert.JSONEq(t, `{"asset":"AAPL","qty":10}`, string(monitor.Position))
+	})
+	r.OnPrice(func(monitor *Monitor) {
+		calls = append(calls, "price")
+	})
+
+	// Sandbox sends every order event as "order" and monitor events as "price" or "position"
+	order := `{"order_id":"7c9a","side":"buy","type":"market","tif":"day","extended_hours":false,"qty":10,` +
+		`"filled_qty":%d,"limit_price":null,"stop_price":null,"avg_fill_price":%s,"status":"%s",` +
+		`"submitted_at":"2021-01-04T15:00:00Z","accepted_at":"2021-01-04T15:00:01Z","created_at":"2021-01-04T15:00:00Z",` +
+		`"updated_at":"2021-01-04T15:00:02Z","filled_at":null,"canceled_at":null,"expired_at":null,"rejected_at":null,` +
+		`"comment":null,"strategy_id":"s1","account_id":"a1","asset":"AAPL"}`
+	r.Handle("order", []byte(fmt.Sprintf(order, 0, "null", "accepted")))
+	r.Handle("order", []byte(fmt.Sprintf(order, 10, "150", "filled")))
+	r.Handle("position", []byte(`{"event":"position_expire","rule":{"pnl":-5},"position":{"asset":"AAPL","qty":10}}`))
+	r.Handle("price", []byte(`{"event":"price","rule":{"asset":"AAPL","price":155},"position":null}`))
+
+	assert.Equal(t, []string{"order_accepted", "filled", "position_expire", "price"}, calls)
+}
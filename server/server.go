@@ -1,71 +1,774 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-var strategyHandler func(tradehook string, payload []byte)
+const (
+	DefaultReadTimeout     = 30 * time.Second
+	DefaultWriteTimeout    = 30 * time.Second
+	DefaultIdleTimeout     = 120 * time.Second
+	DefaultShutdownTimeout = 30 * time.Second
+)
 
-// strategyWrapper retrieve tradehook information from response body and send it to customer strategy
-func strategyWrapper(w http.ResponseWriter, r *http.Request) {
-	var requestBody map[string]interface{}
+var errorHandler func(r *http.Request, err *RequestError)
 
-	body, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		log.Fatal(err)
+// RequestError is a tradehook request which couldn't be delivered to the strategy
+type RequestError struct {
+	// Status is an HTTP status the request was answered with
+	Status int `json:"-"`
+
+	// ID is an error code, e.g. "invalid_json"
+	ID string `json:"id"`
+
+	// Message is a human-readable error description
+	Message string `json:"message"`
+
+	// Tradehook is an event of the request if it was decoded
+	Tradehook string `json:"-"`
+
+	// Body is a request body if it was read
+	Body []byte `json:"-"`
+
+	// Stack is a stack trace of the strategy panic
+	Stack []byte `json:"-"`
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%s: %s", e.ID, e.Message)
+}
+
+// SetErrorHandler sets callback of malformed tradehook requests, strategy panics and rejected requests,
+// errors are logged by default
+func SetErrorHandler(handler func(r *http.Request, err *RequestError)) {
+	errorHandler = handler
+}
+
+// endpoint is a strategy mounted on a URL path of the server
+type endpoint struct {
+	// duplicates is first to keep 64-bit alignment for atomic access
+	duplicates uint64
+
+	strategy     func(tradehook string, payload []byte)
+	auth         *Auth
+	middleware   []func(http.Handler) http.Handler
+	errorHandler func(r *http.Request, err *RequestError)
+	async        *AsyncOptions
+	queue        *queue
+	dedup        *DedupOptions
+	inflightMu   sync.Mutex
+	inflight     map[string]struct{}
+	journalOpts  *JournalOptions
+	journal      *journal
+	metrics      *metrics
+
+	eventMiddleware  []Middleware
+	serverMiddleware []Middleware
+	handle           EventHandler
+}
+
+// EndpointOption configures strategy endpoint
+type EndpointOption func(*endpoint)
+
+// WithAuth rejects requests of the endpoint failing auth checks with 401, e.g. to use own secret per strategy
+func WithAuth(auth *Auth) EndpointOption {
+	return func(e *endpoint) {
+		e.auth = auth
 	}
+}
 
-	err = json.Unmarshal(body, &requestBody)
-	if err != nil {
-		log.Fatal(err)
+// WithMiddleware wraps HTTP handler of the endpoint, the first middleware is the outermost
+func WithMiddleware(middleware ...func(http.Handler) http.Handler) EndpointOption {
+	return func(e *endpoint) {
+		e.middleware = append(e.middleware, middleware...)
 	}
-	rawData := requestBody["data"]
-	data, err := json.Marshal(&rawData)
-	if err != nil {
-		log.Fatal(err)
+}
+
+// WithErrorHandler sets callback of failed requests of the endpoint instead of the one set by SetErrorHandler
+func WithErrorHandler(handler func(r *http.Request, err *RequestError)) EndpointOption {
+	return func(e *endpoint) {
+		e.errorHandler = handler
 	}
+}
 
-	strategyHandler(fmt.Sprintf("%v", requestBody["event"]), data)
+// newEndpoint create new endpoint of the strategy
+func newEndpoint(strategy func(tradehook string, payload []byte), opts ...EndpointOption) (*endpoint, error) {
+	e := &endpoint{strategy: strategy, metrics: newMetrics()}
+	for _, opt := range opts {
+		opt(e)
+	}
 
-	w.WriteHeader(200)
-	_, err = w.Write([]byte(http.StatusText(http.StatusOK)))
-	if err != nil {
-		log.Fatal(err)
+	e.buildHandler()
+
+	if e.journalOpts != nil {
+		j, err := newJournal(*e.journalOpts)
+		if err != nil {
+			return nil, err
+		}
+		e.journal = j
 	}
+	if e.async != nil {
+		e.queue = newQueue(*e.async, e.handleJob)
+	} else if e.journal != nil {
+		// Journaled tradehooks are acknowledged once persisted, so a single worker handles them in order
+		e.queue = newQueue(AsyncOptions{Workers: 1}, e.handleJob)
+	}
+	return e, nil
+}
+
+// handler returns HTTP handler of the endpoint wrapped by its middleware
+func (e *endpoint) handler() http.Handler {
+	var h http.Handler = http.HandlerFunc(e.postMethodOnlyHandler)
+	for i := len(e.middleware) - 1; i >= 0; i-- {
+		h = e.middleware[i](h)
+	}
+	return h
 }
 
 // postMethodOnlyHandler validate request method and execute only 'POST'
-func postMethodOnlyHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method == http.MethodPost {
-		strategyWrapper(w, r)
-	} else {
+func (e *endpoint) postMethodOnlyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
 		w.WriteHeader(405)
 		_, err := w.Write([]byte(http.StatusText(http.StatusMethodNotAllowed)))
 		if err != nil {
-			log.Fatal(err)
+			log.Println(err)
 		}
+		return
+	}
+
+	if e.auth != nil {
+		e.auth.serve(w, r, e.strategyWrapper, func(reqErr *RequestError) {
+			e.writeError(w, r, reqErr)
+		})
+		return
 	}
+
+	e.strategyWrapper(w, r)
 }
 
-// router returns http server mux with selected handler and URL path
-func router(strategy func(tradehook string, payload []byte), endpoint string) http.Handler {
-	strategyHandler = strategy
+// strategyWrapper retrieve tradehook information from response body and send it to customer strategy;
+// malformed requests are answered with 400 and strategy panics with 500
+func (e *endpoint) strategyWrapper(w http.ResponseWriter, r *http.Request) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		e.writeError(w, r, &RequestError{Status: http.StatusBadRequest, ID: "invalid_body", Message: err.Error()})
+		return
+	}
+
+	var requestBody struct {
+		Event *string         `json:"event"`
+		Data  json.RawMessage `json:"data"`
+	}
+	if err = json.Unmarshal(body, &requestBody); err != nil {
+		e.writeError(w, r, &RequestError{Status: http.StatusBadRequest, ID: "invalid_json", Message: err.Error(), Body: body})
+		return
+	}
+	if requestBody.Event == nil || *requestBody.Event == "" {
+		e.writeError(w, r, &RequestError{Status: http.StatusBadRequest, ID: "missing_event", Message: "Tradehook event is required", Body: body})
+		return
+	}
+
+	e.metrics.receive(*requestBody.Event)
+
+	data := []byte(requestBody.Data)
+	if len(data) == 0 {
+		data = []byte("null")
+	}
+
+	var eventID string
+	var queued bool
+	if e.dedup != nil {
+		eventID = e.dedup.ID(r, body)
+
+		// Redelivery of a tradehook which is still handled can't be acknowledged, as the handling could fail
+		if !e.begin(eventID) {
+			w.Header().Set("Retry-After", "1")
+			e.writeError(w, r, &RequestError{
+				Status:    http.StatusConflict,
+				ID:        "in_progress",
+				Message:   "Tradehook is being handled",
+				Tradehook: *requestBody.Event,
+				Body:      body,
+			})
+			return
+		}
+		defer func() {
+			// Queued tradehook is in progress until a worker handles it
+			if !queued {
+				e.finish(eventID)
+			}
+		}()
+	}
+
+	var entry *journalEntry
+	if e.journal != nil {
+		if entry, err = e.journal.append(*requestBody.Event, eventID, data); err != nil {
+			e.writeError(w, r, &RequestError{
+				Status:    http.StatusInternalServerError,
+				ID:        "internal_server_error",
+				Message:   fmt.Sprintf("Journal failed: %v", err),
+				Tradehook: *requestBody.Event,
+				Body:      body,
+			})
+			return
+		}
+	}
+
+	// Id is recorded after the tradehook is journaled, so a crash in between can't make its redelivery a duplicate
+	if e.dedup != nil {
+		added, err := e.dedup.Store.Add(eventID, time.Now().Add(e.dedup.Window))
+		if err != nil {
+			e.discard(entry)
+			e.writeError(w, r, &RequestError{
+				Status:    http.StatusInternalServerError,
+				ID:        "internal_server_error",
+				Message:   fmt.Sprintf("Deduplication failed: %v", err),
+				Tradehook: *requestBody.Event,
+				Body:      body,
+			})
+			return
+		}
+		if !added {
+			e.discard(entry)
+			atomic.AddUint64(&e.duplicates, 1)
+			writeOK(w)
+			return
+		}
+	}
+
+	if e.queue != nil {
+		err = e.queue.enqueue(job{tradehook: *requestBody.Event, eventID: eventID, data: data, request: detach(r), entry: entry})
+		if err != nil {
+			e.forget(eventID)
+			e.discard(entry)
+
+			// Platform redelivers rejected tradehooks, so they aren't lost
+			w.Header().Set("Retry-After", "1")
+			e.writeError(w, r, &RequestError{
+				Status:    http.StatusServiceUnavailable,
+				ID:        "service_unavailable",
+				Message:   err.Error(),
+				Tradehook: *requestBody.Event,
+				Body:      body,
+			})
+			return
+		}
+		queued = true
+	} else if reqErr := e.callStrategy(r, *requestBody.Event, data); reqErr != nil {
+		e.forget(eventID)
+		reqErr.Body = body
+		e.writeError(w, r, reqErr)
+		return
+	}
+
+	writeOK(w)
+}
+
+// forget removes id of the tradehook the strategy failed to handle from dedup store,
+// so its redelivery is handled
+func (e *endpoint) forget(eventID string) {
+	if e.dedup == nil {
+		return
+	}
+	if err := e.dedup.Store.Remove(eventID); err != nil {
+		log.Printf("failed to forget tradehook %s: %v", eventID, err)
+	}
+}
+
+// discard removes journaled tradehook which won't be handled, e.g. a duplicate or rejected one
+func (e *endpoint) discard(entry *journalEntry) {
+	if entry == nil {
+		return
+	}
+	if err := e.journal.done(entry); err != nil {
+		log.Printf("failed to remove tradehook from journal: %v", err)
+	}
+}
+
+// writeOK acknowledges the tradehook
+func writeOK(w http.ResponseWriter) {
+	w.WriteHeader(200)
+	_, err := w.Write([]byte(http.StatusText(http.StatusOK)))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// callStrategy calls strategy handler wrapped by middleware and returns an error if it fails or panics
+func (e *endpoint) callStrategy(r *http.Request, tradehook string, data []byte) (reqErr *RequestError) {
+	start := time.Now()
+	defer func() {
+		e.metrics.observe(time.Since(start))
+
+		if p := recover(); p != nil {
+			reqErr = &RequestError{
+				Status:    http.StatusInternalServerError,
+				ID:        "internal_server_error",
+				Message:   fmt.Sprintf("Strategy panic: %v", p),
+				Tradehook: tradehook,
+				Stack:     debug.Stack(),
+			}
+		}
+	}()
+
+	err := e.handle(&Event{Tradehook: tradehook, Payload: data, Request: r})
+	if err == nil {
+		return nil
+	}
+
+	if !errors.As(err, &reqErr) {
+		return &RequestError{
+			Status:    http.StatusInternalServerError,
+			ID:        "internal_server_error",
+			Message:   err.Error(),
+			Tradehook: tradehook,
+		}
+	}
+	if reqErr.Status == 0 {
+		reqErr.Status = http.StatusInternalServerError
+	}
+	if reqErr.Tradehook == "" {
+		reqErr.Tradehook = tradehook
+	}
+	return reqErr
+}
+
+// handleJob calls strategy with queued tradehook and reports its panic
+func (e *endpoint) handleJob(j job) {
+	if e.dedup != nil {
+		defer e.finish(j.eventID)
+	}
+
+	if j.entry != nil {
+		e.processEntry(j.request, j.entry)
+		return
+	}
+	if reqErr := e.callStrategy(j.request, j.tradehook, j.data); reqErr != nil {
+		reqErr.Body = j.data
+		e.reportError(j.request, reqErr)
+	}
+}
+
+// writeError reports the error to the endpoint error handler and answers the request
+func (e *endpoint) writeError(w http.ResponseWriter, r *http.Request, reqErr *RequestError) {
+	e.reportError(r, reqErr)
+	writeErrorResponse(w, reqErr)
+}
+
+// reportError reports the error to the endpoint error handler or the one set by SetErrorHandler
+func (e *endpoint) reportError(r *http.Request, reqErr *RequestError) {
+	e.metrics.fail(reqErr.ID)
+
+	if e.errorHandler != nil {
+		e.errorHandler(r, reqErr)
+		return
+	}
+	reportError(r, reqErr)
+}
+
+// writeError reports the error to the error handler and answers the request
+func writeError(w http.ResponseWriter, r *http.Request, reqErr *RequestError) {
+	reportError(r, reqErr)
+	writeErrorResponse(w, reqErr)
+}
+
+// reportError reports the error to the handler set by SetErrorHandler or logs it
+func reportError(r *http.Request, reqErr *RequestError) {
+	if errorHandler != nil {
+		errorHandler(r, reqErr)
+		return
+	}
+	log.Printf("tradehook request failed: %v", reqErr)
+}
+
+// writeErrorResponse answers the request with {"errors": [{"id": ..., "message": ...}]}
+func writeErrorResponse(w http.ResponseWriter, reqErr *RequestError) {
+	body, err := json.Marshal(map[string][]*RequestError{"errors": {reqErr}})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(reqErr.Status)
+	if _, err = w.Write(body); err != nil {
+		log.Println(err)
+	}
+}
 
+// router returns http server mux with selected handler and URL path
+func router(strategy func(tradehook string, payload []byte), path string) http.Handler {
 	router := http.NewServeMux()
-	router.HandleFunc(endpoint, postMethodOnlyHandler)
+	e, _ := newEndpoint(strategy)
+	router.Handle(path, e.handler())
 
 	return router
 }
 
+// Server is a strategy server which can be stopped gracefully
+type Server struct {
+	// Addr is a TCP address to listen on, e.g. "0.0.0.0:5000"
+	Addr string
+
+	// ReadTimeout is a maximum duration of reading the entire request
+	ReadTimeout time.Duration
+
+	// WriteTimeout is a maximum duration before timing out writes of the response
+	WriteTimeout time.Duration
+
+	// IdleTimeout is a maximum amount of time to wait for the next request on keep-alive connections
+	IdleTimeout time.Duration
+
+	// ShutdownTimeout limits draining of in-flight tradehooks when Start context is done
+	ShutdownTimeout time.Duration
+
+	// TLSConfig is used when the server is started with TLS
+	TLSConfig *tls.Config
+
+	// CertFile and KeyFile turn on TLS
+	CertFile string
+	KeyFile  string
+
+	// Auth rejects requests failing auth checks with 401
+	Auth *Auth
+
+	// HealthPath, ReadyPath and MetricsPath are URL paths of liveness, readiness and Prometheus metrics
+	// endpoints, DefaultHealthPath, DefaultReadyPath and DefaultMetricsPath by default; empty path disables
+	// the endpoint. They aren't authenticated, so they should only be reachable from a trusted network
+	HealthPath  string
+	ReadyPath   string
+	MetricsPath string
+
+	// ReadinessCheck fails readiness probe of the started server when it returns an error
+	ReadinessCheck func() error
+
+	ready      int32
+	mux        *http.ServeMux
+	endpoints  map[string]*endpoint
+	mu         sync.Mutex
+	httpServer *http.Server
+	listener   net.Listener
+	onShutdown []func()
+	done       chan struct{}
+	middleware []Middleware
+}
+
+// NewServer create new Server with selected host and port, which uses strategy as request handler
+// of the endpoint; more strategies can be added with Handle, strategy can be nil to add them later
+func NewServer(strategy func(tradehook string, payload []byte), path, host string, port int) *Server {
+	s := &Server{
+		Addr:            fmt.Sprintf("%s:%d", host, port),
+		ReadTimeout:     DefaultReadTimeout,
+		WriteTimeout:    DefaultWriteTimeout,
+		IdleTimeout:     DefaultIdleTimeout,
+		ShutdownTimeout: DefaultShutdownTimeout,
+		HealthPath:      DefaultHealthPath,
+		ReadyPath:       DefaultReadyPath,
+		MetricsPath:     DefaultMetricsPath,
+		mux:             http.NewServeMux(),
+		endpoints:       make(map[string]*endpoint),
+	}
+
+	if strategy != nil {
+		// A new server has no endpoints, so the path can't be taken
+		_ = s.Handle(path, strategy)
+	}
+	return s
+}
+
+// Handle mounts strategy on selected URL path, each strategy has its own auth, middleware
+// and error handler set by options
+func (s *Server) Handle(path string, strategy func(tradehook string, payload []byte), opts ...EndpointOption) error {
+	if path == "" {
+		return errors.New("endpoint path is required")
+	}
+	if strategy == nil {
+		return errors.New("strategy is required")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.endpoints[path]; ok {
+		return fmt.Errorf("endpoint %s is already registered", path)
+	}
+
+	e, err := newEndpoint(strategy, opts...)
+	if err != nil {
+		return err
+	}
+	if len(s.middleware) > 0 {
+		e.serverMiddleware = s.middleware
+		e.buildHandler()
+	}
+	s.endpoints[path] = e
+	s.mux.Handle(path, e.handler())
+
+	return nil
+}
+
+// QueueStats returns queue counters of asynchronous endpoints by path
+func (s *Server) QueueStats() map[string]QueueStats {
+	stats := make(map[string]QueueStats)
+	for path, e := range s.endpointMap() {
+		if e.queue != nil {
+			stats[path] = e.queue.stats()
+		}
+	}
+	return stats
+}
+
+// replay handles unfinished tradehooks of journaled endpoints before new ones are accepted
+func (s *Server) replay() error {
+	for path, e := range s.endpointMap() {
+		if e.journal == nil {
+			continue
+		}
+		if err := e.replay(); err != nil {
+			return fmt.Errorf("failed to replay journal of %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// endpointMap returns copy of registered endpoints by path
+func (s *Server) endpointMap() map[string]*endpoint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	endpoints := make(map[string]*endpoint, len(s.endpoints))
+	for path, e := range s.endpoints {
+		endpoints[path] = e
+	}
+	return endpoints
+}
+
+// Handler returns HTTP handler of the server
+func (s *Server) Handler() http.Handler {
+	var strategies http.Handler = s.mux
+	if s.Auth != nil {
+		strategies = s.Auth.Middleware(s.mux)
+	}
+
+	if s.HealthPath == "" && s.ReadyPath == "" && s.MetricsPath == "" {
+		return strategies
+	}
+
+	mux := http.NewServeMux()
+	if s.HealthPath != "" {
+		mux.HandleFunc(s.HealthPath, s.healthHandler)
+	}
+	if s.ReadyPath != "" {
+		mux.HandleFunc(s.ReadyPath, s.readyHandler)
+	}
+	if s.MetricsPath != "" {
+		mux.HandleFunc(s.MetricsPath, s.metricsHandler)
+	}
+	mux.Handle("/", strategies)
+	return mux
+}
+
+// OnShutdown registers function called after in-flight tradehooks are drained on shutdown
+func (s *Server) OnShutdown(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onShutdown = append(s.onShutdown, f)
+}
+
+// ListenAddr returns address the server listens on, or empty string if it isn't started
+func (s *Server) ListenAddr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// Start replays unfinished journaled tradehooks and serves requests until the context is done
+// or Shutdown is called; returns nil after graceful shutdown, or an error if the server can't be started
+func (s *Server) Start(ctx context.Context) error {
+	httpServer := &http.Server{
+		Handler:      s.Handler(),
+		ReadTimeout:  s.ReadTimeout,
+		WriteTimeout: s.WriteTimeout,
+		IdleTimeout:  s.IdleTimeout,
+		TLSConfig:    s.TLSConfig,
+	}
+
+	// Server is taken before the replay, so a second Start doesn't handle journaled tradehooks again
+	s.mu.Lock()
+	if s.httpServer != nil {
+		s.mu.Unlock()
+		return errors.New("server is already started")
+	}
+	s.httpServer = httpServer
+	s.done = make(chan struct{})
+	done := s.done
+	s.mu.Unlock()
+
+	// Queues closed by the previous Shutdown handle tradehooks of the restarted server
+	for _, e := range s.endpointMap() {
+		if e.queue != nil {
+			e.queue.reopen()
+		}
+	}
+
+	if err := s.checkTLS(); err != nil {
+		s.reset(httpServer)
+		return err
+	}
+	if err := s.replay(); err != nil {
+		s.reset(httpServer)
+		return err
+	}
+
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		s.reset(httpServer)
+		return err
+	}
+
+	s.mu.Lock()
+	if s.httpServer == httpServer {
+		s.listener = listener
+	}
+	s.mu.Unlock()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if s.CertFile != "" || s.TLSConfig != nil {
+			serveErr <- httpServer.ServeTLS(listener, s.CertFile, s.KeyFile)
+		} else {
+			serveErr <- httpServer.Serve(listener)
+		}
+	}()
+	// Listener is bound and certificates are loaded, so serving only fails when it's shut down
+	s.mu.Lock()
+	if s.httpServer == httpServer {
+		atomic.StoreInt32(&s.ready, 1)
+	}
+	s.mu.Unlock()
+
+	select {
+	case err = <-serveErr:
+		if err == http.ErrServerClosed {
+			// Shutdown was called, wait until in-flight tradehooks are drained
+			<-done
+			return nil
+		}
+		s.reset(httpServer)
+		return err
+	case <-ctx.Done():
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+		defer cancel()
+
+		return s.Shutdown(shutdownCtx)
+	}
+}
+
+// Shutdown stops accepting new requests, waits for in-flight tradehooks until the context is done
+// and calls OnShutdown functions
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	// Readiness probe fails while in-flight tradehooks are drained
+	atomic.StoreInt32(&s.ready, 0)
+	httpServer, done := s.httpServer, s.done
+	hooks := s.onShutdown
+	s.onShutdown = nil
+	s.done = nil
+	s.mu.Unlock()
+
+	var err error
+	if httpServer != nil {
+		err = httpServer.Shutdown(ctx)
+	}
+
+	// Tradehooks acknowledged by asynchronous endpoints are handled before shutdown hooks
+	for _, e := range s.endpointMap() {
+		if e.queue == nil {
+			continue
+		}
+		if qErr := e.queue.close(ctx); qErr != nil && err == nil {
+			err = qErr
+		}
+	}
+
+	for _, f := range hooks {
+		f()
+	}
+	s.reset(httpServer)
+	if done != nil {
+		close(done)
+	}
+	return err
+}
+
+// reset forgets stopped HTTP server, so the server isn't ready and can be started again
+func (s *Server) reset(httpServer *http.Server) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if httpServer != nil && s.httpServer == httpServer {
+		atomic.StoreInt32(&s.ready, 0)
+		s.httpServer, s.listener = nil, nil
+	}
+}
+
+// checkTLS loads certificate files of TLS server, so Start fails before the server is ready if they're invalid.
+// Certificates of TLSConfig are used as they are
+func (s *Server) checkTLS() error {
+	if s.CertFile == "" && s.TLSConfig == nil {
+		return nil
+	}
+
+	config := s.TLSConfig
+	hasCert := config != nil && (len(config.Certificates) > 0 || config.GetCertificate != nil || config.GetConfigForClient != nil)
+	if hasCert && s.CertFile == "" && s.KeyFile == "" {
+		return nil
+	}
+
+	_, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	return err
+}
+
 // Start create new server with selected host and port, and use strategy as request handler
 func Start(strategy func(tradehook string, payload []byte), endpoint, host string, port int) {
-
-	err := http.ListenAndServe(fmt.Sprintf("%s:%d", host, port), router(strategy, endpoint))
+	err := newLegacyServer(strategy, endpoint, host, port).Start(context.Background())
 	if err != nil {
 		log.Fatalln(err)
 	}
 }
+
+// StartWithAuth create new server like Start, which rejects requests failing auth checks with 401
+// before they reach the strategy
+func StartWithAuth(strategy func(tradehook string, payload []byte), endpoint, host string, port int, auth *Auth) {
+	s := newLegacyServer(strategy, endpoint, host, port)
+	s.Auth = auth
+
+	if err := s.Start(context.Background()); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// newLegacyServer create new Server of Start functions without timeouts, which they never had,
+// so synchronous strategies can take as long as they need
+func newLegacyServer(strategy func(tradehook string, payload []byte), endpoint, host string, port int) *Server {
+	s := NewServer(strategy, endpoint, host, port)
+	s.ReadTimeout, s.WriteTimeout, s.IdleTimeout = 0, 0, 0
+	return s
+}
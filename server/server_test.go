@@ -2,6 +2,7 @@ package server
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
@@ -9,7 +10,9 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 )
 
 const invalidErrorMsg = "invalid response"
@@ -67,3 +70,203 @@ func TestRunServerWithStrategy(t *testing.T) {
 	assert.Equal(t, 200, res.StatusCode, invalidErrorMsg)
 	cls(res.Body)
 }
+
+func TestServerAnswersMalformedRequests(t *testing.T) {
+	var reported []*RequestError
+	SetErrorHandler(func(r *http.Request, err *RequestError) {
+		reported = append(reported, err)
+	})
+	defer SetErrorHandler(nil)
+
+	handler := router(func(tradehook string, payload []byte) {
+		if tradehook == "panic" {
+			panic("boom")
+		}
+	}, "/")
+
+	for _, c := range []struct {
+		body   string
+		status int
+		id     string
+	}{
+		{`{"event":`, http.StatusBadRequest, "invalid_json"},
+		{`{"data":{}}`, http.StatusBadRequest, "missing_event"},
+		{`{"event":"panic","data":{}}`, http.StatusInternalServerError, "internal_server_error"},
+	} {
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(c.body)))
+		assert.Equal(t, c.status, res.Code, c.body)
+
+		var body struct {
+			Errors []Error `json:"errors"`
+		}
+		assert.NoError(t, json.Unmarshal(res.Body.Bytes(), &body))
+		assert.Equal(t, c.id, body.Errors[0].ID, c.body)
+	}
+
+	assert.Equal(t, 3, len(reported))
+	assert.Equal(t, "panic", reported[2].Tradehook)
+	assert.NotEmpty(t, reported[2].Stack)
+
+	// Server keeps serving after the panic
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/", bytes.NewBufferString(`{"event":"bar","data":{}}`)))
+	assert.Equal(t, http.StatusOK, res.Code)
+}
+
+func TestServerShutsDownGracefully(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	var handled bool
+
+	s := NewServer(func(tradehook string, payload []byte) {
+		close(started)
+		<-release
+		handled = true
+	}, "/", "127.0.0.1", 0)
+
+	var shutdown bool
+	s.OnShutdown(func() {
+		shutdown = true
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Start(ctx)
+	}()
+	assert.Eventually(t, func() bool {
+		return s.ListenAddr() != ""
+	}, time.Second, 10*time.Millisecond)
+	addr := s.ListenAddr()
+
+	// Send a tradehook and stop the server while it's being handled
+	answered := make(chan int, 1)
+	go func() {
+		res, err := http.Post(fmt.Sprintf("http://%s/", addr), "", bytes.NewBufferString(`{"event":"bar","data":{}}`))
+		if assert.NoError(t, err) {
+			answered <- res.StatusCode
+			cls(res.Body)
+		}
+	}()
+	<-started
+	cancel()
+
+	select {
+	case <-stopped:
+		t.Fatal("server stopped before in-flight tradehook was handled")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	assert.NoError(t, <-stopped)
+	assert.Equal(t, http.StatusOK, <-answered)
+	assert.True(t, handled)
+	assert.True(t, shutdown)
+
+	// New requests are refused
+	_, err := http.Post(fmt.Sprintf("http://%s/", addr), "", bytes.NewBufferString(`{}`))
+	assert.Error(t, err)
+	assert.Equal(t, "", s.ListenAddr())
+}
+
+func TestStartKeepsNoTimeouts(t *testing.T) {
+	s := newLegacyServer(func(tradehook string, payload []byte) {}, "/", "127.0.0.1", 0)
+	assert.Zero(t, s.ReadTimeout)
+	assert.Zero(t, s.WriteTimeout)
+	assert.Zero(t, s.IdleTimeout)
+}
+
+func TestServerRestarts(t *testing.T) {
+	handled := make(chan string, 1)
+
+	s := NewServer(nil, "", "127.0.0.1", 0)
+	assert.NoError(t, s.Handle("/", func(tradehook string, payload []byte) {
+		handled <- tradehook
+	}, WithAsync(AsyncOptions{Workers: 1, QueueSize: 8})))
+
+	for _, tradehook := range []string{"order_filled", "order_rejected"} {
+		ctx, cancel := context.WithCancel(context.Background())
+		stopped := make(chan error, 1)
+		go func() {
+			stopped <- s.Start(ctx)
+		}()
+		assert.Eventually(t, func() bool {
+			return s.checkReady() == nil
+		}, time.Second, 10*time.Millisecond)
+
+		res, err := http.Post(fmt.Sprintf("http://%s/", s.ListenAddr()), "", bytes.NewBufferString(`{"event":"`+tradehook+`","data":{}}`))
+		if assert.NoError(t, err) {
+			assert.Equal(t, http.StatusOK, res.StatusCode)
+			cls(res.Body)
+		}
+		assert.Equal(t, tradehook, <-handled)
+
+		cancel()
+		assert.NoError(t, <-stopped)
+		assert.Error(t, s.checkReady())
+	}
+}
+
+func TestServerFailsToStartWithInvalidCertificate(t *testing.T) {
+	s := NewServer(func(tradehook string, payload []byte) {}, "/", "127.0.0.1", 0)
+	s.CertFile, s.KeyFile = "testdata/missing.crt", "testdata/missing.key"
+
+	assert.Error(t, s.Start(context.Background()))
+	assert.Error(t, s.checkReady())
+	assert.Equal(t, "", s.ListenAddr())
+
+	// Server is started once the certificate is fixed
+	s.CertFile, s.KeyFile = "", ""
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- s.Start(ctx)
+	}()
+	assert.Eventually(t, func() bool {
+		return s.checkReady() == nil
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-stopped)
+}
+
+func TestServerHostsSeveralStrategies(t *testing.T) {
+	var calls []string
+	s := NewServer(func(tradehook string, payload []byte) {
+		calls = append(calls, "first:"+tradehook)
+	}, "/first", "127.0.0.1", 0)
+
+	var middlewareCalls int
+	now := time.Now()
+	assert.NoError(t, s.Handle("/second", func(tradehook string, payload []byte) {
+		calls = append(calls, "second:"+tradehook)
+	}, WithAuth(&Auth{Secret: "secret"}), WithMiddleware(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			middlewareCalls++
+			next.ServeHTTP(w, r)
+		})
+	})))
+	assert.Error(t, s.Handle("/first", func(tradehook string, payload []byte) {}))
+
+	handler := s.Handler()
+	body := []byte(`{"event":"bar","data":{}}`)
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/first", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	// Second strategy requires signed requests
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/second", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusUnauthorized, res.Code)
+
+	req := httptest.NewRequest(http.MethodPost, "/second", bytes.NewReader(body))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(SignatureHeader, Sign("secret", now, body))
+	res = httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+
+	assert.Equal(t, []string{"first:bar", "second:bar"}, calls)
+	assert.Equal(t, 2, middlewareCalls)
+}